@@ -0,0 +1,147 @@
+package rivet
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+// AmbiguousError 表示有多个关联变量实现了被注入的接口类型, 参见 Context.PickAssignable.
+type AmbiguousError struct {
+	Type       reflect.Type   // 被注入的接口类型
+	Candidates []reflect.Type // 实现了该接口的变量类型
+}
+
+func (e *AmbiguousError) Error() string {
+	a := make([]string, len(e.Candidates))
+	for i, t := range e.Candidates {
+		a[i] = t.String()
+	}
+	return fmt.Sprintf("rivet: ambiguous %s implemented by %s", e.Type, strings.Join(a, ", "))
+}
+
+func (e *AmbiguousError) Status() int         { return http.StatusInternalServerError }
+func (e *AmbiguousError) Header() http.Header { return nil }
+func (e *AmbiguousError) Message() string     { return http.StatusText(http.StatusInternalServerError) }
+
+// PickAssignable 在关联的变量和 provider 中查找实现了接口类型 t 的变量,
+// 返回该变量和它的类型指针, 之后可以直接以该类型指针调用 Pick. 查找顺序同 Pick,
+// 但不包括 Pick 直接支持的 *Context, Params, http.ResponseWriter, *http.Request, context.Context.
+// 同一层级中有多个变量实现了 t 时返回 *AmbiguousError, 下一层级不再查找.
+// t 不是接口类型或者没有找到时返回的类型指针为 nil.
+func (c *Context) PickAssignable(t reflect.Type) (interface{}, unsafe.Pointer, error) {
+	if t.Kind() != reflect.Interface {
+		return nil, nil, nil
+	}
+
+	s := c.searchRequest(t)
+	if s.keys == nil {
+		s = c.searchInjector(t)
+	}
+	return c.resolve(t, s)
+}
+
+// searchRequest 在请求期关联的变量和 provider 中查找实现了 t 的类型.
+func (c *Context) searchRequest(t reflect.Type) (s search) {
+	for k, v := range c.partner {
+		if v != nil {
+			s.add(t, k, reflect.TypeOf(v))
+		}
+	}
+	for k, p := range c.providers {
+		if p != nil {
+			s.add(t, k, p.fn.Type().Out(0))
+		}
+	}
+	return
+}
+
+// searchInjector 在 c.injector 及其父级中查找实现了 t 的类型, 找到的第一层级为结果.
+func (c *Context) searchInjector(t reflect.Type) (s search) {
+	for i := c.injector; s.keys == nil && i != nil; i = i.parent {
+		for k, v := range i.values {
+			if v != nil {
+				s.add(t, k, reflect.TypeOf(v))
+			}
+		}
+		for k, p := range i.providers {
+			if _, ok := i.values[k]; !ok {
+				s.add(t, k, p.fn.Type().Out(0))
+			}
+		}
+	}
+	return
+}
+
+// resolve 返回 s 中唯一的候选变量, 有多个候选时返回 *AmbiguousError.
+func (c *Context) resolve(t reflect.Type, s search) (interface{}, unsafe.Pointer, error) {
+	switch len(s.keys) {
+	case 0:
+		return nil, nil, nil
+	case 1:
+		v, ok := c.Pick(s.keys[0])
+		if !ok {
+			return nil, nil, nil
+		}
+		return v, s.keys[0], nil
+	}
+	return nil, nil, &AmbiguousError{Type: t, Candidates: s.types}
+}
+
+// search 收集实现了接口的候选类型.
+type search struct {
+	keys  []unsafe.Pointer
+	types []reflect.Type
+}
+
+func (s *search) add(t reflect.Type, key unsafe.Pointer, typ reflect.Type) {
+	if !typ.Implements(t) {
+		return
+	}
+	for _, k := range s.keys {
+		if k == key {
+			return
+		}
+	}
+	s.keys = append(s.keys, key)
+	s.types = append(s.types, typ)
+}
+
+// pick 以类型指针 id 调用 Pick, 失败时如果 c.assignable 为 true 且 t 是接口类型,
+// 按 PickAssignable 的规则查找. 请求期关联的变量每次都重新查找, 只有在其中没有实现了 t
+// 的变量时, 才使用 cache 中缓存的类型指针. cache 只保存在 Injector 中找到的类型,
+// Injector 在处理请求期间不变, 所以缓存的类型仍是唯一的候选.
+// 产生的 *AmbiguousError 交由 HandleError 处理.
+func (c *Context) pick(id unsafe.Pointer, t reflect.Type, cache *unsafe.Pointer) (interface{}, bool) {
+	v, ok := c.Pick(id)
+	if ok || !c.assignable || t.Kind() != reflect.Interface {
+		return v, ok
+	}
+
+	s := c.searchRequest(t)
+	shared := s.keys == nil
+	if shared {
+		if key := atomic.LoadPointer(cache); key != nil {
+			if v, ok = c.Pick(key); ok && v != nil && reflect.TypeOf(v).Implements(t) {
+				return v, true
+			}
+		}
+		s = c.searchInjector(t)
+	}
+
+	v, key, err := c.resolve(t, s)
+	if err != nil {
+		c.HandleError(err)
+		return nil, false
+	}
+	if key == nil {
+		return nil, false
+	}
+	if shared {
+		atomic.StorePointer(cache, key)
+	}
+	return v, true
+}
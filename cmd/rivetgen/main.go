@@ -0,0 +1,485 @@
+/*
+rivetgen 为 handler 函数生成 rivet.Dispatcher 实现, 替代 dispatcher 的反射调用.
+
+rivetgen 查找包中直接传递给 rivet 注册方法 (Get, Post, Handle, Use, Group, ToDispatcher 等)
+的包级函数, 例如 r.Get("/", Foo) 中的 Foo. 参数为 ToDispatcher 直接支持的签名
+(如 func(*rivet.Context), func(http.ResponseWriter, *http.Request)) 的函数不需要生成.
+查找只依据语法, 不检查方法的接收者类型, 也无法发现以变量传递的函数, 此时可以在
+函数的文档注释中添加一行 "//rivet:handler" 进行标记. 在包中添加:
+
+  //go:generate rivetgen
+
+对函数 Foo, 生成的代码包含:
+
+  type dispatchFoo [N]unsafe.Pointer      // 预先计算的参数类型指针, N 为参数个数
+  var FooDispatcher rivet.FuncDispatcher  // 未导出的函数 foo 对应 fooDispatcher
+
+并在 init 中以 rivet.RegisterDispatcher 注册, 之后 ToDispatcher 遇到 Foo 时使用
+FooDispatcher, 注册路由的代码无需修改, 也可以直接使用:
+
+  r.Get("/", FooDispatcher)
+
+生成的 Dispatch 以 Context.Pick 取得参数, 直接调用函数, 参数无法注入或类型不符时调用
+Context.Unresolved, 返回值的处理与 rivet.ToDispatcher 相同.
+生成的代码只以类型指针精确查找参数, 不支持 rivet.In 结构体参数和 Rivet.Assignable.
+查找到的函数有 rivet.In 结构体参数时被忽略, 标记的函数则报告错误. 含有接口类型参数
+(http.ResponseWriter, context.Context 除外) 的函数不会被 RegisterDispatcher 注册,
+ToDispatcher 仍以反射调用它, 以免 Rivet.Assignable 失效, 这时需直接使用 FooDispatcher.
+
+用法:
+
+  rivetgen [-o rivet_gen.go] [-tag rivet:handler] [dir]
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const rivetPath = "github.com/typepress/rivet"
+
+// fixed 是生成的代码总是使用的导入.
+var fixed = map[string]string{
+	"http":    "net/http",
+	"reflect": "reflect",
+	"unsafe":  "unsafe",
+	"rivet":   rivetPath,
+}
+
+var (
+	output = flag.String("o", "rivet_gen.go", "输出文件名, 相对于包目录")
+	tag    = flag.String("tag", "rivet:handler", "标记 handler 函数的注释")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: rivetgen [flags] [dir]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	src, err := generate(dir, *output, *tag)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, *output), src, 0644)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "rivetgen:", err)
+		os.Exit(1)
+	}
+}
+
+// handler 是需要生成 Dispatcher 的函数.
+type handler struct {
+	name    string
+	params  []param
+	results []string
+}
+
+type param struct {
+	typ      string // 参数类型, 可变参数为 []T
+	variadic bool
+}
+
+// registers 是注册 handler 的方法和函数名.
+var registers = map[string]bool{
+	"Get": true, "Post": true, "Put": true, "Patch": true, "Delete": true,
+	"Options": true, "Head": true, "Any": true, "Handle": true,
+	"Use": true, "Group": true, "ToDispatcher": true, "Defer": true,
+}
+
+// generate 解析 dir 中的包, 返回生成的代码. 文件名为 output 的文件被忽略.
+func generate(dir, output, tag string) ([]byte, error) {
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	used := map[string]bool{}    // 传递给注册方法的标识符
+	inTypes := map[string]bool{} // 嵌入了 rivet.In 的结构体类型名
+
+	for _, name := range pkg.GoFiles {
+		if name == output {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+
+		names := fileImports(f)
+		ast.Inspect(f, func(n ast.Node) bool {
+			if spec, ok := n.(*ast.TypeSpec); ok {
+				if st, ok := spec.Type.(*ast.StructType); ok && embedsIn(st, names) {
+					inTypes[spec.Name.Name] = true
+				}
+				return true
+			}
+
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || !registers[sel.Sel.Name] {
+				return true
+			}
+			for _, arg := range call.Args {
+				if id, ok := arg.(*ast.Ident); ok {
+					used[id.Name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	imports := map[string]string{} // name -> path
+	var handlers []handler
+
+	for _, f := range files {
+		names := fileImports(f)
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			generic := fn.Recv != nil || fn.Type.TypeParams != nil
+			in := hasIn(fn.Type, names, inTypes)
+			if marked(fn.Doc, tag) {
+				if generic {
+					return nil, fmt.Errorf("%s: %s must be a non-generic func", fset.Position(fn.Pos()), fn.Name.Name)
+				}
+				if in {
+					return nil, fmt.Errorf("%s: %s has a rivet.In parameter", fset.Position(fn.Pos()), fn.Name.Name)
+				}
+			} else if generic || in || !used[fn.Name.Name] || builtin(fn.Type, names) {
+				continue
+			}
+
+			h, err := newHandler(fset, fn, names, imports)
+			if err != nil {
+				return nil, err
+			}
+			handlers = append(handlers, h)
+		}
+	}
+
+	if len(handlers) == 0 {
+		return nil, fmt.Errorf("no handler func found in %s", dir)
+	}
+
+	var buf bytes.Buffer
+	write(&buf, pkg.Name, imports, handlers)
+	return format.Source(buf.Bytes())
+}
+
+// builtin 返回 ToDispatcher 是否直接支持函数类型 ft, 不需要生成 Dispatcher.
+func builtin(ft *ast.FuncType, names map[string]string) bool {
+	key := func(fl *ast.FieldList) string {
+		if fl == nil {
+			return ""
+		}
+		var a []string
+		for _, f := range fl.List {
+			n := len(f.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				a = append(a, typeKey(f.Type, names))
+			}
+		}
+		return strings.Join(a, ", ")
+	}
+
+	params, results := key(ft.Params), key(ft.Results)
+	switch params {
+	case "":
+		return results == ""
+	case "*" + rivetPath + ".Context",
+		"net/http.ResponseWriter, *net/http.Request",
+		rivetPath + ".Params, net/http.ResponseWriter, *net/http.Request":
+		return results == "" || results == "bool"
+	}
+	return false
+}
+
+// hasIn 返回函数类型 ft 是否有嵌入了 rivet.In 的结构体参数, inTypes 为包中此类结构体的类型名.
+// 其它包中定义的此类结构体无法识别, 由 rivet.RegisterDispatcher 拒绝注册.
+func hasIn(ft *ast.FuncType, names map[string]string, inTypes map[string]bool) bool {
+	for _, f := range ft.Params.List {
+		typ := f.Type
+		if x, ok := typ.(*ast.StarExpr); ok {
+			typ = x.X
+		}
+		switch x := typ.(type) {
+		case *ast.Ident:
+			if inTypes[x.Name] {
+				return true
+			}
+		case *ast.StructType:
+			if embedsIn(x, names) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// embedsIn 返回结构体 st 是否嵌入了 rivet.In.
+func embedsIn(st *ast.StructType, names map[string]string) bool {
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 && typeKey(f.Type, names) == rivetPath+".In" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeKey 返回以导入路径限定的类型名, 只处理指针和限定标识符, 其它类型返回空字符串.
+func typeKey(x ast.Expr, names map[string]string) string {
+	switch x := x.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.StarExpr:
+		return "*" + typeKey(x.X, names)
+	case *ast.SelectorExpr:
+		if id, ok := x.X.(*ast.Ident); ok {
+			return names[id.Name] + "." + x.Sel.Name
+		}
+	}
+	return ""
+}
+
+// marked 返回文档注释中是否有一行为 "//" + tag.
+func marked(doc *ast.CommentGroup, tag string) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == "//"+tag {
+			return true
+		}
+	}
+	return false
+}
+
+// fileImports 返回 f 中以名字为键的导入路径.
+func fileImports(f *ast.File) map[string]string {
+	names := map[string]string{}
+	for _, spec := range f.Imports {
+		p, _ := strconv.Unquote(spec.Path.Value)
+		name := path.Base(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		names[name] = p
+	}
+	return names
+}
+
+func newHandler(fset *token.FileSet, fn *ast.FuncDecl, names, imports map[string]string) (handler, error) {
+	h := handler{name: fn.Name.Name}
+
+	// 收集类型中引用的包
+	var err error
+	ast.Inspect(fn.Type, func(n ast.Node) bool {
+		sel, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if x, ok := sel.X.(*ast.Ident); ok {
+			p, ok := names[x.Name]
+			if !ok {
+				return true
+			}
+			if q, ok := fixed[x.Name]; ok && q != p {
+				err = fmt.Errorf("%s: import name %s conflicts with %s", fset.Position(x.Pos()), x.Name, q)
+			}
+			if q, ok := imports[x.Name]; ok && q != p {
+				err = fmt.Errorf("%s: import name %s conflicts: %s, %s", fset.Position(x.Pos()), x.Name, p, q)
+			}
+			imports[x.Name] = p
+		}
+		return false
+	})
+	if err != nil {
+		return h, err
+	}
+
+	for _, f := range fn.Type.Params.List {
+		p := param{}
+		typ := f.Type
+		if e, ok := typ.(*ast.Ellipsis); ok {
+			p.variadic = true
+			typ = e.Elt
+		}
+		p.typ = expr(fset, typ)
+		if p.variadic {
+			p.typ = "[]" + p.typ
+		}
+
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			h.params = append(h.params, p)
+		}
+	}
+
+	if fn.Type.Results != nil {
+		for _, f := range fn.Type.Results.List {
+			n := len(f.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				h.results = append(h.results, expr(fset, f.Type))
+			}
+		}
+	}
+	return h, nil
+}
+
+func expr(fset *token.FileSet, x ast.Expr) string {
+	var buf bytes.Buffer
+	format.Node(&buf, fset, x)
+	return buf.String()
+}
+
+func write(buf *bytes.Buffer, pkg string, imports map[string]string, handlers []handler) {
+	useReflect := false
+	for _, h := range handlers {
+		useReflect = useReflect || len(h.params) != 0
+	}
+
+	var std, other []string
+	for name, p := range fixed {
+		if name != "reflect" || useReflect {
+			imports[name] = p
+		}
+	}
+	for name, p := range imports {
+		spec := strconv.Quote(p)
+		if path.Base(p) != name {
+			spec = name + " " + spec
+		}
+		if strings.Contains(strings.Split(p, "/")[0], ".") {
+			other = append(other, spec)
+		} else {
+			std = append(std, spec)
+		}
+	}
+	sort.Strings(std)
+	sort.Strings(other)
+
+	fmt.Fprintf(buf, "// Code generated by rivetgen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg)
+	fmt.Fprintf(buf, "\t%s\n\n\t%s\n)\n", strings.Join(std, "\n\t"), strings.Join(other, "\n\t"))
+
+	for _, h := range handlers {
+		writeHandler(buf, pkg, h)
+	}
+
+	buf.WriteString("\nfunc init() {\n")
+	for _, h := range handlers {
+		fmt.Fprintf(buf, "\trivet.RegisterDispatcher(%sDispatcher)\n", h.name)
+	}
+	buf.WriteString("}\n")
+}
+
+func writeHandler(buf *bytes.Buffer, pkg string, h handler) {
+	typ := "dispatch" + h.name
+	v := h.name + "Dispatcher"
+
+	fmt.Fprintf(buf, "\n// %s 以注入方式调用 %s, 元素为参数的类型指针.\n", typ, h.name)
+	fmt.Fprintf(buf, "type %s [%d]unsafe.Pointer\n\n", typ, len(h.params))
+	fmt.Fprintf(buf, "// %s 是注入调用 %s 的 rivet.FuncDispatcher.\n", v, h.name)
+	fmt.Fprintf(buf, "var %s rivet.FuncDispatcher = %s{\n", v, typ)
+	for _, p := range h.params {
+		fmt.Fprintf(buf, "\trivet.TypePointerOf([]%s{}),\n", p.typ)
+	}
+	buf.WriteString("}\n\n")
+
+	fmt.Fprintf(buf, "func (d %s) Func() interface{} { return %s }\n", typ, h.name)
+	fmt.Fprintf(buf, "func (d %s) IsInjector() bool { return true }\n", typ)
+	fmt.Fprintf(buf, "func (d %s) Hand(rivet.Params, http.ResponseWriter, *http.Request) bool { return true }\n", typ)
+	fmt.Fprintf(buf, "func (d %s) Dispatch(c *rivet.Context) bool {\n", typ)
+
+	args := make([]string, len(h.params))
+	for i, p := range h.params {
+		args[i] = fmt.Sprintf("a%d", i)
+		// nil 值以零值调用, 同 reflect.Zero
+		fmt.Fprintf(buf, "\tv%d, ok := c.Pick(d[%d])\n", i, i)
+		fmt.Fprintf(buf, "\ta%d, match := v%d.(%s)\n", i, i, p.typ)
+		fmt.Fprintf(buf, "\tif !ok || !match && v%d != nil {\n", i)
+		fmt.Fprintf(buf, "\t\tc.Unresolved(%q, reflect.TypeOf([]%s{}).Elem())\n\t\treturn false\n\t}\n", pkg+"."+h.name, p.typ)
+	}
+	if n := len(h.params); n > 0 && h.params[n-1].variadic {
+		args[n-1] += "..."
+	}
+	call := h.name + "(" + strings.Join(args, ", ") + ")"
+
+	results := h.results
+	if len(results) == 0 {
+		fmt.Fprintf(buf, "\t%s\n\treturn true\n}\n", call)
+		return
+	}
+
+	n := len(results)
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("r%d", i)
+	}
+
+	// (..., error)
+	check := results[n-1] == "error"
+	if check {
+		results = results[:n-1]
+	}
+
+	render := ""
+	switch {
+	case len(results) == 1:
+		render = "c.Render(0, r0)"
+	case len(results) == 2 && results[0] == "int":
+		render = "c.Render(r0, r1)"
+	default:
+		for i := range results {
+			out[i] = "_"
+		}
+	}
+
+	if render == "" && !check {
+		fmt.Fprintf(buf, "\t%s\n\treturn true\n}\n", call)
+		return
+	}
+
+	fmt.Fprintf(buf, "\t%s := %s\n", strings.Join(out, ", "), call)
+	if check {
+		fmt.Fprintf(buf, "\tif r%d != nil {\n\t\tc.HandleError(r%d)\n\t\treturn false\n\t}\n", n-1, n-1)
+	}
+	if render == "" {
+		render = "true"
+	}
+	fmt.Fprintf(buf, "\treturn %s\n}\n", render)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "更新 testdata 中的 golden 文件")
+
+func TestGenerate(t *testing.T) {
+	src, err := generate("testdata/example", "rivet_gen.go", "rivet:handler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "rivet_gen.golden")
+	if *update {
+		if err := os.WriteFile(golden, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, want) {
+		t.Fatalf("generated code differs from %s, run go test -update:\n%s", golden, src)
+	}
+
+	// 在临时 GOPATH 中编译生成的代码并运行 testdata 中的测试
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	if testing.Short() {
+		t.Skip("skipping compile in short mode")
+	}
+
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gopath := t.TempDir()
+	rivet := filepath.Join(gopath, "src", rivetPath)
+	pkg := filepath.Join(gopath, "src", "example")
+	if err := os.MkdirAll(filepath.Dir(rivet), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(root, rivet); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(pkg, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob("testdata/example/*.go")
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(pkg, filepath.Base(name)), b, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(pkg, "rivet_gen.go"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(gotool, "test", "example")
+	cmd.Dir = pkg
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
@@ -0,0 +1,81 @@
+package example
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/typepress/rivet"
+)
+
+// DB 是注入的服务.
+type DB struct {
+	Name string
+}
+
+// Hello 以 r.Get 注册.
+func Hello(p rivet.Params, db *DB) (string, error) {
+	if db == nil {
+		return "", errors.New("no db")
+	}
+	return "hello " + p.Get("name") + " from " + db.Name, nil
+}
+
+// Created 返回状态码和值.
+func Created(req *http.Request) (int, interface{}) {
+	return http.StatusCreated, req.URL.Path
+}
+
+// Plain 是 ToDispatcher 直接支持的签名, 不生成.
+func Plain(rw http.ResponseWriter, req *http.Request) {
+	rw.Write([]byte("plain"))
+}
+
+// Sum 以变量传递, 需要标记.
+//
+//rivet:handler
+func Sum(nums ...int) string {
+	n := 0
+	for _, v := range nums {
+		n += v
+	}
+	return strconv.Itoa(n)
+}
+
+// Greeter 实现 fmt.Stringer.
+type Greeter struct{}
+
+func (Greeter) String() string { return "greeter" }
+
+// Greet 有接口类型参数, 生成但不注册, 以便按 Rivet.Assignable 查找.
+func Greet(s fmt.Stringer) string {
+	return s.String()
+}
+
+// Services 嵌入了 rivet.In.
+type Services struct {
+	rivet.In
+	DB *DB
+}
+
+// Service 有 rivet.In 结构体参数, 不生成.
+func Service(s *Services) string {
+	return s.DB.Name
+}
+
+func logger(rw http.ResponseWriter, db *DB) {
+	rw.Header().Set("X-DB", db.Name)
+}
+
+// Routes 注册路由.
+func Routes(r *rivet.Rivet) {
+	sum := Sum
+	r.Use(logger)
+	r.Get("/hello/:name", Hello)
+	r.Post("/created", Created)
+	r.Get("/plain", Plain)
+	r.Get("/sum", sum)
+	r.Get("/greet", Greet)
+	r.Get("/service", Service)
+}
@@ -0,0 +1,70 @@
+package example
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/typepress/rivet"
+)
+
+func TestRoutes(t *testing.T) {
+	for _, fn := range []interface{}{Hello, Created, Sum, logger} {
+		if _, ok := rivet.ToDispatcher(fn).(rivet.FuncDispatcher); !ok {
+			t.Fatalf("%s is not generated", rivet.Describe(fn))
+		}
+	}
+	for _, fn := range []interface{}{Plain, Greet, Service} {
+		if _, ok := rivet.ToDispatcher(fn).(rivet.FuncDispatcher); ok {
+			t.Fatalf("%s should be dispatched by reflection", rivet.Describe(fn))
+		}
+	}
+	if got := rivet.Describe(rivet.ToDispatcher(Hello)); got != "example.Hello" {
+		t.Fatalf("Describe: %s", got)
+	}
+
+	r := rivet.New()
+	r.Verify = true
+	r.Map(&DB{Name: "db"})
+	r.Map(Greeter{})
+	r.Assignable = true
+	r.MapTo([]int{1, 2, 3}, [][]int{})
+	Routes(r)
+
+	if err := rivet.Verify(rivet.NewInjector(nil), Hello); err == nil || !strings.Contains(err.Error(), "*example.DB") {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	for _, x := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"GET", "/hello/rivet", "hello rivet from db", 200},
+		{"POST", "/created", "/created", 201},
+		{"GET", "/plain", "plain", 200},
+		{"GET", "/sum", "6", 200},
+		{"GET", "/greet", "greeter", 200},
+		{"GET", "/service", "db", 200},
+	} {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest(x.method, x.path, nil))
+		if rw.Code != x.code || !strings.Contains(rw.Body.String(), x.body) || rw.Header().Get("X-DB") != "db" {
+			t.Fatalf("%s %s: %d %q", x.method, x.path, rw.Code, rw.Body.String())
+		}
+	}
+
+	// 生成的 Dispatcher 以精确类型检查
+	if err := rivet.Verify(r.Injector(), GreetDispatcher); err == nil {
+		t.Fatal("want InjectError")
+	}
+
+	// 类型不符的关联变量以 Unresolved 报告
+	r = rivet.New()
+	r.Get("/hello/:name", func(c *rivet.Context) { c.MapTo("db", (**DB)(nil)) }, Hello)
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/hello/x", nil))
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatalf("mismatch: %d %q", rw.Code, rw.Body.String())
+	}
+}
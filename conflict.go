@@ -0,0 +1,182 @@
+package rivet
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConflictError 表示 Pattern 与已注册的路由 Existing 冲突.
+type ConflictError struct {
+	Pattern     string // 新注册的 pattern
+	Existing    string // 已注册的 pattern
+	Unreachable bool   // true 表示 Pattern 永远匹配不到, 否则表示两者有歧义
+}
+
+func (e *ConflictError) Error() string {
+	if e.Unreachable {
+		return fmt.Sprintf("rivet: pattern %q is unreachable, shadowed by %q", e.Pattern, e.Existing)
+	}
+	return fmt.Sprintf("rivet: pattern %q is ambiguous with %q", e.Pattern, e.Existing)
+}
+
+// Check 检查 path 是否与 t 中已有的路由冲突, 冲突时返回 *ConflictError.
+// path 参见 Lookup. 冲突是指两个 pattern 在每一段上都可能匹配相同的字符串, 比如:
+//
+//   "/:id" 与 "/:name"          参数名不同
+//   "/:id" 与 "/:name alpha"    ":id" 可匹配 alpha 的所有字符串
+//   "/a/*" 与 "/a/:name"        ":name" 优先于 "*"
+//   "/a/**" 与 "/a/**.go"       "**" 可匹配 "**.go" 的所有字符串
+//
+// 定值优先于参数匹配, 所以 "/users/new" 与 "/users/:id" 不冲突.
+// 无法判断的正则 Matcher 不被视为冲突. 相同的 pattern 被视为替换而不是冲突.
+func (t *Trie) Check(path string) error {
+	if t.parent != nil {
+		path = t.parent.String() + path
+	}
+
+	b := tokenize(path, t.sep)
+
+	return t.Walk(func(n *Trie) error {
+		existing := n.String()
+		if existing == path {
+			return nil
+		}
+
+		a := tokenize(existing, t.sep)
+		if len(a) != len(b) {
+			return nil
+		}
+
+		unreachable := true
+		for i := range a {
+			if !a[i].overlap(b[i]) {
+				return nil
+			}
+			if !a[i].covers(b[i]) {
+				unreachable = false
+			}
+		}
+
+		return &ConflictError{Pattern: path, Existing: existing, Unreachable: unreachable}
+	})
+}
+
+// Check 检查 method 中 pattern 是否与已有的路由冲突, 参见 Trie.Check.
+func (r Router) Check(method, pattern string) error {
+	if method == "*" {
+		method = "any"
+	}
+
+	if t := r[method]; t != nil {
+		return t.Check(pattern)
+	}
+	return nil
+}
+
+// token 是 pattern 的一段, kind 取值同 Trie.kind, 但 ":name" 统一为 1.
+type token struct {
+	kind uint8
+	text string // 定值, Matcher 表达式, "**" 的后缀或 "x?"
+}
+
+// tokenize 按照 Trie.mix 的规则拆分 pattern.
+func tokenize(path string, sep byte) []token {
+	var a []token
+
+	fixed := func(s string) {
+		if l := len(a) - 1; l >= 0 && a[l].kind == 0xff {
+			a[l].text += s
+		} else {
+			a = append(a, token{0xff, s})
+		}
+	}
+
+	for path != "" {
+		i := strings.IndexAny(path, ":*?")
+		if i == -1 {
+			fixed(path)
+			break
+		}
+
+		if path[i] == '?' && i != 0 {
+			i--
+		}
+
+		if i != 0 {
+			fixed(path[:i])
+			path = path[i:]
+		}
+
+		switch {
+		case path[0] == ':':
+			i = strings.IndexByte(path, sep)
+			if i == -1 {
+				i = len(path)
+			}
+
+			if i == 1 {
+				fixed(":")
+			} else if k := strings.IndexByte(path[:i], ' '); k == -1 {
+				a = append(a, token{1, ""})
+			} else {
+				a = append(a, token{1, strings.TrimSpace(path[k+1 : i])})
+			}
+			path = path[i:]
+
+		case strings.HasPrefix(path, "**"):
+			a = append(a, token{0xfd, path[2:]})
+			path = ""
+
+		case path[0] == '*':
+			a = append(a, token{0xfc, ""})
+			path = path[1:]
+
+		case path[0] == '?':
+			a = append(a, token{0xfe, "?"})
+			path = path[1:]
+
+		default:
+			a = append(a, token{0xfe, path[:2]})
+			path = path[2:]
+		}
+	}
+	return a
+}
+
+// isString 返回参数段是否可以匹配任意字符串.
+func (t token) isString() bool {
+	return t.kind == 1 && (t.text == "" || t.text == "string")
+}
+
+// overlap 返回 t, o 是否可能匹配相同的字符串.
+func (t token) overlap(o token) bool {
+	switch {
+	case t.kind == o.kind && t.text == o.text:
+		return true
+	case t.kind == 1 && o.kind == 1:
+		return t.isString() || o.isString()
+	case t.kind == 0xfc && o.kind == 1:
+		return o.isString()
+	case t.kind == 1 && o.kind == 0xfc:
+		return t.isString()
+	case t.kind == 0xfd && o.kind == 0xfd:
+		return t.text == "" || o.text == ""
+	}
+	return false
+}
+
+// covers 返回匹配时 t 是否优先于 o 并且能匹配 o 能匹配的所有字符串.
+// 调用前 t, o 必须是 overlap 的.
+func (t token) covers(o token) bool {
+	switch {
+	case t.kind == o.kind && t.text == o.text:
+		return true
+	case t.kind == 1 && o.kind == 1:
+		return t.isString()
+	case t.kind == 1 && o.kind == 0xfc:
+		return true
+	case t.kind == 0xfd && o.kind == 0xfd:
+		return t.text == ""
+	}
+	return false
+}
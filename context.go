@@ -1,9 +1,11 @@
 package rivet
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"reflect"
+	"sync"
 	"unsafe"
 )
 
@@ -12,6 +14,7 @@ var (
 	idResponseWriter = TypePointerOf([]http.ResponseWriter{})
 	idContext        = TypePointerOf([]*Context{})
 	idParams         = TypePointerOf([]Params{})
+	idStdContext     = TypePointerOf([]context.Context{})
 )
 
 // emptyInterface 是 interface{} 的通用结构. 参见 reflect:emptyInterface.
@@ -61,11 +64,30 @@ type Context struct {
 	// 使用前您需要先 make 它.
 	Store   map[string]interface{}
 	partner map[unsafe.Pointer]interface{} // 保存响应期关联变量
+
+	// mu 保护 partner 和 Req 的写入, 以便其它 goroutine 调用 Context.Context 的 Value.
+	mu sync.RWMutex
+
+	// providers 保存尚未调用的 provider, 调用后被标记为 nil, 结果保存到 partner.
+	providers map[unsafe.Pointer]*provider
+
+	// injector 是请求共享的 Injector, 在 partner 和 providers 中找不到时使用.
+	injector *Injector
+
+	// assignable 为 true 时, 注入调用在接口类型参数 Pick 失败后调用 PickAssignable.
+	assignable bool
+
+	// handleError 是生成 Context 的 Rivet 或 HostRouter 的错误处理方法.
+	handleError func(error, http.ResponseWriter, *http.Request)
+
+	// handled 是 HandleError 被调用的次数.
+	handled int
 }
 
 // Pick 返回类型指针 t 为键值的关联变量.
 // 如果 t 表示 Context, Params, http.ResponseWriter, *http.Request 类型,
-// Pick 直接返回 c 或者相应成员, 否则返回 MapTo 关联的变量.
+// Pick 直接返回 c 或者相应成员, context.Context 类型返回 c.Context(), 否则返回 MapTo 关联的变量, 或者调用 Provide 注册的 provider,
+// 最后在 Injector 中查找.
 func (c *Context) Pick(t unsafe.Pointer) (v interface{}, ok bool) {
 	switch t {
 	case idContext:
@@ -76,13 +98,43 @@ func (c *Context) Pick(t unsafe.Pointer) (v interface{}, ok bool) {
 		return c.Res, true
 	case idParams:
 		return c.Params, true
+	case idStdContext:
+		return c.Context(), true
 	}
 	if c.partner != nil {
 		v, ok = c.partner[t]
 	}
+
+	if !ok && c.providers != nil {
+		if p := c.providers[t]; p != nil {
+			// 标记为 nil, 避免循环依赖, 结果会被缓存
+			c.providers[t] = nil
+			v, ok = p.call(c)
+		}
+	}
+
+	if !ok && c.injector != nil {
+		v, ok = c.injector.pick(c, t)
+	}
 	return
 }
 
+// Provide 注册 provider 函数 fn, fn 的第一个返回值类型的变量在首次被 Pick 时才生成.
+// fn 的形式为 func(...) T 或 func(...) (T, error), 参数以注入方式获得.
+// 生成的变量被关联到 c, 之后的 Pick 直接返回它. 如果 fn 返回错误,
+// 错误交由 HandleError 处理, Pick 返回 false, 这将终止注入调用.
+// 已经以 MapTo 关联的类型优先于 provider. 不符合要求的 fn 会产生 panic.
+func (c *Context) Provide(fn interface{}) {
+	c.provide(newProvider(fn))
+}
+
+func (c *Context) provide(p *provider) {
+	if c.providers == nil {
+		c.providers = make(map[unsafe.Pointer]*provider, 1)
+	}
+	c.providers[p.out] = p
+}
+
 // Map 等同 MapTo(v, v).
 func (c *Context) Map(v interface{}) {
 	c.MapTo(v, v)
@@ -91,13 +143,118 @@ func (c *Context) Map(v interface{}) {
 // MapTo 以 TypePointerOf(t) 为键值把变量 v 关联到 context. 相同 t 值只保留一个.
 // 无需保存 Context, Params, http.ResponseWriter, *http.Request 类型变量, 参见 Pick.
 func (c *Context) MapTo(v interface{}, t interface{}) {
+	c.set(TypePointerOf(t), v)
+}
+
+// set 以类型指针 t 为键值关联 v.
+func (c *Context) set(t unsafe.Pointer, v interface{}) {
+	c.mu.Lock()
 	if c.partner == nil {
 		c.partner = make(map[unsafe.Pointer]interface{}, 1)
 	}
-	c.partner[TypePointerOf(t)] = v
+	c.partner[t] = v
+	c.mu.Unlock()
+}
+
+// HandleError 处理 err, 并以 error 类型关联 err 到 c, 参见 Defer.
+// 使用生成 c 的 Rivet 或 HostRouter 的 HandleError, 如果没有则使用包方法 HandleError.
+// 注入调用的 handler 返回的错误由此方法处理.
+func (c *Context) HandleError(err error) {
+	c.handled++
+	c.MapTo(err, []error{})
+	if c.handleError != nil {
+		c.handleError(err, c.Res, c.Req)
+	} else {
+		HandleError(err, c.Res, c.Req)
+	}
 }
 
 // WriteString 是个便捷方法
 func (c *Context) WriteString(s string) (int, error) {
 	return io.WriteString(c.Res, s)
 }
+
+// Context 返回以 c.Req.Context() 为基础的 context.Context.
+// Deadline, Done, Err 与 c.Req.Context() 相同, 当 Value 的参数 key 为 unsafe.Pointer 时,
+// 返回 key 为键值的关联变量, 这样以 MapTo 关联的变量对使用 context.Context 的库可见. 例如:
+//
+//   v := ctx.Value(rivet.TypePointerOf([]*sql.DB{}))
+//
+// 与 Pick 不同, Value 可以被多个 goroutine 同时调用, 它只返回 Pick 直接支持的类型,
+// 已经关联到 c 的变量以及 Injector 中关联的变量, 不调用 provider, 也不处理错误.
+// 返回值不会随之后的 WithContext 改变. c.Req 为 nil 时以 context.Background() 为基础.
+// 注入 context.Context 类型的参数得到的是该方法的返回值.
+func (c *Context) Context() context.Context {
+	ctx := context.Background()
+	if c.Req != nil {
+		ctx = c.Req.Context()
+	}
+	return requestContext{ctx, c}
+}
+
+// WithContext 以 c.Req.WithContext(ctx) 替换 c.Req, 之后的 handler 注入的 *http.Request,
+// context.Context 以及 Context.Context 都基于 ctx. 例如设置超时:
+//
+//   ctx, cancel := context.WithTimeout(c.Context(), time.Second)
+//   defer cancel()
+//   c.WithContext(ctx)
+//
+// handler 中直接调用 req.WithContext 只得到新的 *http.Request, 不影响之后的 handler.
+// 不注入 *Context 的 handler 可以返回该 *http.Request, 效果相同, 参见 ToDispatcher:
+//
+//   func(req *http.Request) *http.Request {
+//       return req.WithContext(ctx)
+//   }
+//
+// func(http.ResponseWriter, *http.Request) 等不以注入方式调用的 handler 无法替换 c.Req.
+func (c *Context) WithContext(ctx context.Context) {
+	c.setRequest(c.Req.WithContext(ctx))
+}
+
+// setRequest 以 req 替换 c.Req.
+func (c *Context) setRequest(req *http.Request) {
+	c.mu.Lock()
+	c.Req = req
+	c.mu.Unlock()
+}
+
+// requestContext 实现 Context.Context.
+type requestContext struct {
+	context.Context
+	c *Context
+}
+
+func (ctx requestContext) Value(key interface{}) interface{} {
+	if t, ok := key.(unsafe.Pointer); ok {
+		return ctx.c.value(t)
+	}
+	return ctx.Context.Value(key)
+}
+
+// value 实现 requestContext.Value, 只读取变量, 可以被多个 goroutine 同时调用.
+func (c *Context) value(t unsafe.Pointer) interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	switch t {
+	case idContext:
+		return c
+	case idRequest:
+		return c.Req
+	case idResponseWriter:
+		return c.Res
+	case idParams:
+		return c.Params
+	case idStdContext:
+		return c.Context()
+	}
+	if v, ok := c.partner[t]; ok {
+		return v
+	}
+	for i := c.injector; i != nil; i = i.parent {
+		if v, ok := i.values[t]; ok {
+			return v
+		}
+	}
+	return nil
+}
@@ -1,9 +1,9 @@
 package rivet
 
 import (
-	"io"
 	"net/http"
 	"reflect"
+	"runtime/debug"
 	"unsafe"
 )
 
@@ -12,6 +12,7 @@ var (
 	idBytes  = TypePointerOf([][]byte{})
 	idError  = TypePointerOf([]error{})
 	idBool   = TypePointerOf([]bool{})
+	idInt    = TypePointerOf([]int{})
 )
 
 // Dispatcher 接口用于派发
@@ -24,23 +25,67 @@ type Dispatcher interface {
 
 type dispatchs struct {
 	queue      []Dispatcher
+	after      []Dispatcher // Defer 包装的 handler, 总是在最后以相反的顺序执行
 	isInjector bool
 }
 
+// call 以 c 派发 d, 根据 d.IsInjector 选择 Dispatch 或 Hand.
+func call(d Dispatcher, c *Context) bool {
+	if d.IsInjector() {
+		return d.Dispatch(c)
+	}
+	return d.Hand(c.Params, c.Res, c.Req)
+}
+
 func (ds dispatchs) IsInjector() bool { return ds.isInjector }
 func (ds dispatchs) Dispatch(c *Context) bool {
+	return ds.around(c, nil)
+}
+
+// around 依次派发 queue, 全部成功后调用 next, 最后总是派发 after.
+func (ds dispatchs) around(c *Context, next func() bool) bool {
+	if len(ds.after) != 0 {
+		res, ok := c.Res.(*Response)
+		if !ok {
+			res = NewResponse(c.Res)
+			c.Res = res
+		}
+		c.Map(res)
+		defer ds.finish(c)
+	}
+
 	for _, d := range ds.queue {
-		if d.IsInjector() {
-			if !d.Dispatch(c) {
-				return false
-			}
-		} else if !d.Hand(c.Params, c.Res, c.Req) {
+		if !call(d, c) {
 			return false
 		}
 	}
+
+	if next != nil {
+		return next()
+	}
 	return true
 }
 
+// finish 以相反的顺序派发 after, 忽略返回值.
+// 如果之前的 handler 产生了 panic, 关联 *PanicError 作为 error, 派发 after 后重新 panic.
+// 如果之前的 handler 没有产生错误, 关联一个 nil error, 以便 after 可以注入 error 参数.
+func (ds dispatchs) finish(c *Context) {
+	v := recover()
+	if v != nil {
+		c.MapTo(&PanicError{Value: v, Stack: debug.Stack()}, []error{})
+	} else if _, ok := c.Pick(idError); !ok {
+		c.MapTo(nil, []error{})
+	}
+
+	for i := len(ds.after) - 1; i >= 0; i-- {
+		call(ds.after[i], c)
+	}
+
+	if v != nil {
+		panic(v)
+	}
+}
+
 func (ds dispatchs) Hand(p Params, rw http.ResponseWriter, req *http.Request) bool {
 	for _, d := range ds.queue {
 		if !d.IsInjector() && !d.Hand(p, rw, req) {
@@ -54,6 +99,8 @@ func (ds dispatchs) Hand(p Params, rw http.ResponseWriter, req *http.Request) bo
 type dispatcher struct {
 	fn         reflect.Value
 	in         []unsafe.Pointer
+	args       []*object        // 对应 in, 非 nil 的元素表示 In 结构体参数, 没有时为 nil
+	impl       []unsafe.Pointer // 对应 in, 缓存接口类型参数的实现类型, 参见 Rivet.Assignable
 	out        []unsafe.Pointer
 	isVariadic bool
 }
@@ -69,12 +116,24 @@ func (d dispatcher) Dispatch(c *Context) bool {
 
 	in := make([]reflect.Value, len(d.in))
 	for i := 0; i < len(d.in); i++ {
-		v, has = c.Pick(d.in[i])
+		if d.args != nil && d.args[i] != nil {
+			if in[i], has = d.args[i].build(c, d.fn); !has {
+				return false
+			}
+			continue
+		}
+
+		v, has = c.pick(d.in[i], d.fn.Type().In(i), &d.impl[i])
 		if !has {
+			c.Unresolved(funcName(d.fn), d.fn.Type().In(i))
 			return false
 		}
 
-		in[i] = reflect.ValueOf(v)
+		if v == nil {
+			in[i] = reflect.Zero(d.fn.Type().In(i))
+		} else {
+			in[i] = reflect.ValueOf(v)
+		}
 	}
 
 	if d.isVariadic {
@@ -83,43 +142,26 @@ func (d dispatcher) Dispatch(c *Context) bool {
 		out = d.fn.Call(in)
 	}
 
-	if d.out == nil {
+	n := len(out)
+	if n == 0 {
 		return true
 	}
 
-	switch d.out[0] {
-	case idString:
-		io.WriteString(c.Res, out[0].String())
-
-	case idBytes:
-		c.Res.Write(out[0].Bytes())
-
-	case idError:
-		if !out[0].IsNil() {
-			err, ok := out[0].Interface().(error)
-
-			if ok {
-				HandleError(err, c.Res, c.Req)
-				return false
-			}
-		}
-	case idBool:
-		if !out[0].Bool() {
+	// (..., error)
+	if d.out[n-1] == idError {
+		if !out[n-1].IsNil() {
+			c.HandleError(out[n-1].Interface().(error))
 			return false
 		}
+		n--
 	}
 
-	if len(d.out) == 1 || out[1].IsNil() {
-		return true
-	}
-
-	err, ok := out[1].Interface().(error)
-
-	if ok && err != nil {
-		HandleError(err, c.Res, c.Req)
-		return false
+	switch {
+	case n == 1:
+		return render(c, 0, out[0].Interface())
+	case n == 2 && d.out[0] == idInt:
+		return render(c, int(out[0].Int()), out[1].Interface())
 	}
-
 	return true
 }
 
@@ -199,11 +241,42 @@ func (d dispatch) Dispatch(c *Context) bool {
 	return true
 }
 
+type deferred struct {
+	Dispatcher
+}
+
+// Defer 包装 handler, 在 ToDispatcher 中使用. 被包装的 handler 在其它 handler 之后,
+// 以与添加顺序相反的顺序执行. 无论之前的 handler 是否返回 false 或者产生 panic,
+// 它们总是被执行, 且返回值被忽略. 适用于日志, 统计, 事务提交或回滚等. 此时:
+//
+//   Context.Res 被包装为 *Response, 可以注入 *Response 获取响应状态码.
+//   可以注入 error 参数, 获得注入调用或者路由匹配产生的错误, 没有错误时为 nil.
+//   产生 panic 时 error 为 *PanicError, 被包装的 handler 执行后 panic 继续传播.
+//
+// 参数 handler 支持的类型同 ToDispatcher. 例如:
+//
+//   r.Get("/", rivet.Defer(logger), auth, index)
+func Defer(handler ...interface{}) Dispatcher {
+	return deferred{ToDispatcher(handler...)}
+}
+
 // ToDispatcher 包装 handler 为 Dispatcher.
+// 其它类型的函数以注入方式反射调用, 返回值的处理方式:
+//
+//   (T)              以 render 规则输出 T, 参见 Renderers.
+//   (*http.Request)  非 nil 时替换 Context.Req, 之后的 handler 注入的是该值.
+//   (T, error)       error 非 nil 时交由 Context.HandleError 处理, 否则输出 T.
+//   (int, T)         以 int 为响应状态码输出 T.
+//   (int, T, error)  组合上述两种.
+//
+// 嵌入了 In 的结构体参数不以类型整体注入, 而是逐个注入其字段, 参见 In.
+// 以 RegisterDispatcher 注册过的函数使用注册的 FuncDispatcher, 不使用反射调用.
+//
 // 特别的, 如果 handler 函数中包含 Store 类型参数
 func ToDispatcher(handler ...interface{}) Dispatcher {
 	var fun reflect.Value
 	var withContext bool
+	var after []Dispatcher
 
 	ds := make([]Dispatcher, 0)
 	for _, i := range handler {
@@ -237,6 +310,12 @@ func ToDispatcher(handler ...interface{}) Dispatcher {
 			ds = append(ds, dispatchParams{r: d})
 			continue
 
+		case deferred:
+			if d.Dispatcher != nil {
+				after = append(after, d.Dispatcher)
+			}
+			continue
+
 		// Dispatcher 接口优先于其它接口.
 		case Dispatcher:
 			if d.IsInjector() {
@@ -249,41 +328,24 @@ func ToDispatcher(handler ...interface{}) Dispatcher {
 			continue
 
 		default:
+			// 注入调用和关联变量都需要 Context
+			withContext = true
 			fun = reflect.ValueOf(i)
 			if fun.Kind() != reflect.Func {
 				ds = append(ds, dispatch{i})
 				continue
 			}
-		}
-
-		t := fun.Type()
-		d := dispatcher{
-			fn:         fun,
-			in:         make([]unsafe.Pointer, t.NumIn()),
-			isVariadic: t.IsVariadic(),
-		}
-
-		for i := 0; i < t.NumIn(); i++ {
-			d.in[i] = TypePointerOf(t.In(i))
-			if d.in[i] == idContext {
-				withContext = true
+			if d := generated[fun.Pointer()]; d != nil {
+				ds = append(ds, d)
+				continue
 			}
 		}
 
-		if t.NumOut() > 0 && t.NumOut() <= 2 {
-
-			out := make([]unsafe.Pointer, t.NumOut())
-
-			for i := 0; i < t.NumOut(); i++ {
-				out[i] = TypePointerOf(t.Out(i))
-			}
-			if out[0] == idError || out[0] == idString || out[0] == idBytes || out[0] == idBool ||
-				len(out) == 2 && out[1] == idError {
-				d.out = out
-			}
-		}
+		ds = append(ds, newDispatcher(fun))
+	}
 
-		ds = append(ds, d)
+	if len(after) != 0 {
+		return dispatchs{queue: ds, after: after, isInjector: true}
 	}
 
 	if len(ds) == 0 {
@@ -294,3 +356,32 @@ func ToDispatcher(handler ...interface{}) Dispatcher {
 	}
 	return dispatchs{queue: ds, isInjector: withContext}
 }
+
+// newDispatcher 分析函数 fun 的参数和返回值, 返回注入调用的 dispatcher.
+func newDispatcher(fun reflect.Value) dispatcher {
+	t := fun.Type()
+	d := dispatcher{
+		fn:         fun,
+		in:         make([]unsafe.Pointer, t.NumIn()),
+		impl:       make([]unsafe.Pointer, t.NumIn()),
+		isVariadic: t.IsVariadic(),
+	}
+
+	for i := 0; i < t.NumIn(); i++ {
+		d.in[i] = TypePointerOf(t.In(i))
+		if o := newObject(t.In(i)); o != nil {
+			if d.args == nil {
+				d.args = make([]*object, t.NumIn())
+			}
+			d.args[i] = o
+		}
+	}
+
+	if t.NumOut() > 0 {
+		d.out = make([]unsafe.Pointer, t.NumOut())
+		for i := 0; i < t.NumOut(); i++ {
+			d.out[i] = TypePointerOf(t.Out(i))
+		}
+	}
+	return d
+}
@@ -0,0 +1,48 @@
+package rivet
+
+import "reflect"
+
+// FuncDispatcher 是以生成的代码注入调用函数的 Dispatcher, 参见 cmd/rivetgen.
+// Func 返回被调用的函数, Verify 和 Describe 以它分析参数和名称.
+type FuncDispatcher interface {
+	Dispatcher
+	Func() interface{}
+}
+
+// generated 以函数入口地址为键值保存 RegisterDispatcher 注册的 FuncDispatcher.
+var generated = map[uintptr]FuncDispatcher{}
+
+// RegisterDispatcher 注册 d, 之后 ToDispatcher 遇到 d.Func() 函数时使用 d 替代反射调用.
+// 通常由 rivetgen 生成的 init 函数调用, 只应注册包级函数, 因为同一函数字面量产生的
+// 闭包共享入口地址. d.Func() 不是函数时产生 panic.
+//
+// 生成的代码只以类型指针精确查找参数. 函数含有 In 结构体参数, 或者除 http.ResponseWriter,
+// context.Context 以外的接口类型参数 (可能按 Rivet.Assignable 查找) 时不注册,
+// ToDispatcher 仍以反射调用该函数.
+func RegisterDispatcher(d FuncDispatcher) {
+	v := reflect.ValueOf(d.Func())
+	if v.Kind() != reflect.Func || v.IsNil() {
+		panic("rivet: FuncDispatcher.Func must return a func")
+	}
+	if exact(v.Type()) {
+		generated[v.Pointer()] = d
+	}
+}
+
+// exact 返回函数类型 t 的参数是否都只需以类型指针精确查找.
+func exact(t reflect.Type) bool {
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if newObject(in) != nil {
+			return false
+		}
+		if in.Kind() == reflect.Interface {
+			switch TypePointerOf(in) {
+			case idResponseWriter, idStdContext:
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package rivet
+
+// Group 是共享路由前缀和 handler 的路由分组, 由 Rivet.Group 生成.
+// 通过 Group 注册路由时, pattern 前会加上前缀,
+// handler 之前会加上分组共享的 handler, 然后交给 Rivet.Handle 处理.
+type Group struct {
+	rivet    *Rivet
+	parent   *Group
+	prefix   string
+	handler  []interface{}
+	injector *Injector // 分组作用域, 参见 Group.Injector
+}
+
+// Group 返回以 prefix 为前缀, 共享 handler 的路由分组. 例如:
+//
+//   admin := r.Group("/admin", auth)
+//   admin.Get("/users", users) // 等同 r.Get("/admin/users", auth, users)
+func (r *Rivet) Group(prefix string, handler ...interface{}) *Group {
+	return &Group{rivet: r, prefix: prefix, handler: handler}
+}
+
+// Group 返回嵌套的路由分组, 前缀和共享 handler 追加在 g 之后.
+func (g *Group) Group(prefix string, handler ...interface{}) *Group {
+	h := make([]interface{}, 0, len(g.handler)+len(handler))
+	return &Group{
+		rivet:   g.rivet,
+		parent:  g,
+		prefix:  g.prefix + prefix,
+		handler: append(append(h, g.handler...), handler...),
+	}
+}
+
+// Prefix 返回分组的完整前缀.
+func (g *Group) Prefix() string {
+	return g.prefix
+}
+
+// join 返回共享 handler 与 handler 合并后的新 slice.
+// 如果 g 或上级分组有作用域, 最前面是设置最近作用域的 handler.
+func (g *Group) join(handler []interface{}) []interface{} {
+	h := make([]interface{}, 0, len(g.handler)+len(handler)+1)
+	for p := g; p != nil; p = p.parent {
+		if p.injector != nil {
+			h = append(h, Scope(p.injector))
+			break
+		}
+	}
+	return append(append(h, g.handler...), handler...)
+}
+
+func (g *Group) Get(pattern string, handler ...interface{}) *Trie {
+	return g.Handle("GET", pattern, handler...)
+}
+
+func (g *Group) Post(pattern string, handler ...interface{}) *Trie {
+	return g.Handle("POST", pattern, handler...)
+}
+
+func (g *Group) Put(pattern string, handler ...interface{}) *Trie {
+	return g.Handle("PUT", pattern, handler...)
+}
+
+func (g *Group) Patch(pattern string, handler ...interface{}) *Trie {
+	return g.Handle("PATCH", pattern, handler...)
+}
+
+func (g *Group) Delete(pattern string, handler ...interface{}) *Trie {
+	return g.Handle("DELETE", pattern, handler...)
+}
+
+func (g *Group) Options(pattern string, handler ...interface{}) *Trie {
+	return g.Handle("OPTIONS", pattern, handler...)
+}
+
+func (g *Group) Head(pattern string, handler ...interface{}) *Trie {
+	return g.Handle("HEAD", pattern, handler...)
+}
+
+func (g *Group) Any(pattern string, handler ...interface{}) *Trie {
+	return g.Handle("any", pattern, handler...)
+}
+
+// Handle 以 g 的前缀和共享 handler 调用 Rivet.Handle.
+// 如果 handler 为空, 共享 handler 也不会被添加, 返回的 Trie.Word 为 nil.
+func (g *Group) Handle(method string, pattern string, handler ...interface{}) *Trie {
+	if len(handler) == 0 {
+		return g.rivet.Handle(method, g.prefix+pattern)
+	}
+	return g.rivet.Handle(method, g.prefix+pattern, g.join(handler)...)
+}
+
+// Name 为路由节点 t 命名, 参见 Rivet.Name.
+func (g *Group) Name(name string, t *Trie) *Trie {
+	return g.rivet.Name(name, t)
+}
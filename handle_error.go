@@ -1,39 +1,174 @@
 package rivet
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 const StatusNotFound = StatusError(http.StatusNotFound)
 const StatusNotImplemented = StatusError(http.StatusNotImplemented)
 
+// HTTPError 是可被 HandleError 识别的错误, 携带 HTTP 状态码, 响应头和公开消息.
+// 未实现 HTTPError 的错误被当做 400 Bad Request 处理.
+type HTTPError interface {
+	error
+
+	// Status 返回 HTTP 状态码.
+	Status() int
+
+	// Header 返回需要附加到响应的 Header, 可以为 nil.
+	Header() http.Header
+
+	// Message 返回可以公开给客户端的消息, 为空时使用状态码对应的文本.
+	Message() string
+}
+
+// StatusError 以 HTTP 状态码表示错误, 实现了 HTTPError.
 type StatusError int
 
 func (code StatusError) Error() string {
 	return http.StatusText(int(code))
 }
 
-// HandleError 是 Rivet 缺省的错误处理方法
-func HandleError(err error, rw http.ResponseWriter, req *http.Request) {
-	if err == nil || err == io.EOF {
-		return
+func (code StatusError) Status() int         { return int(code) }
+func (code StatusError) Header() http.Header { return nil }
+func (code StatusError) Message() string     { return code.Error() }
+
+// MethodNotAllowed 表示 URL.Path 只能在其它 HTTP method 中匹配到,
+// 其值为这些可匹配的 method, 用于生成响应头 "Allow". 实现了 HTTPError.
+type MethodNotAllowed []string
+
+func (e MethodNotAllowed) Error() string {
+	return http.StatusText(http.StatusMethodNotAllowed)
+}
+
+// Allow 返回响应头 "Allow" 的值.
+func (e MethodNotAllowed) Allow() string {
+	return strings.Join(e, ", ")
+}
+
+func (e MethodNotAllowed) Status() int     { return http.StatusMethodNotAllowed }
+func (e MethodNotAllowed) Message() string { return e.Error() }
+func (e MethodNotAllowed) Header() http.Header {
+	return http.Header{"Allow": {e.Allow()}}
+}
+
+// PanicError 是从 panic 恢复后生成的错误, 参见 Rivet.Recover.
+// 实现了 HTTPError, 状态码为 500, 公开消息不包含 panic 的值.
+type PanicError struct {
+	Value interface{} // recover 得到的值
+	Stack []byte      // 产生 panic 时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprint("rivet: panic: ", e.Value)
+}
+
+func (e *PanicError) Status() int         { return http.StatusInternalServerError }
+func (e *PanicError) Header() http.Header { return nil }
+func (e *PanicError) Message() string     { return http.StatusText(http.StatusInternalServerError) }
+
+// withStatus 为任意错误附加状态码和响应头.
+type withStatus struct {
+	err    error
+	code   int
+	header http.Header
+}
+
+func (e *withStatus) Error() string       { return e.err.Error() }
+func (e *withStatus) Unwrap() error       { return e.err }
+func (e *withStatus) Status() int         { return e.code }
+func (e *withStatus) Header() http.Header { return e.header }
+func (e *withStatus) Message() string {
+	if e.code >= http.StatusInternalServerError {
+		return http.StatusText(e.code)
+	}
+	var he HTTPError
+	if errors.As(e.err, &he) {
+		return he.Message()
+	}
+	return e.err.Error()
+}
+
+// WithStatus 为 err 附加 HTTP 状态码 code, 返回的 HTTPError 以 err.Error() 为公开消息,
+// 如果 err 是 HTTPError 则保持其 Message, code 为 5xx 时以 http.StatusText(code) 为公开消息,
+// 以免泄露内部错误. 如果 err 为 nil 返回 nil. 例如 Matcher 或 handler 中:
+//
+//   return rivet.WithStatus(errors.New("user exists"), http.StatusConflict)
+func WithStatus(err error, code int) HTTPError {
+	if err == nil {
+		return nil
+	}
+
+	if e, ok := err.(*withStatus); ok {
+		return &withStatus{e.err, code, e.header}
+	}
+	return &withStatus{err, code, nil}
+}
+
+// WithHeader 为 err 附加响应头 key: value, 状态码和公开消息保持不变. 如果 err 为 nil 返回 nil.
+func WithHeader(err error, key, value string) HTTPError {
+	if err == nil {
+		return nil
 	}
-	code, ok := echo(err).(int)
 
-	if !ok {
-		code = http.StatusBadRequest
+	e, ok := err.(*withStatus)
+	if ok {
+		e = &withStatus{e.err, e.code, cloneHeader(e.header)}
+	} else {
+		code, header, _ := statusOf(err)
+		e = &withStatus{err, code, cloneHeader(header)}
 	}
 
-	msg := err.Error()
+	e.header.Add(key, value)
+	return e
+}
+
+func cloneHeader(h http.Header) http.Header {
+	c := make(http.Header, len(h)+1)
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// statusOf 返回 err 的状态码, 响应头和公开消息.
+func statusOf(err error) (code int, header http.Header, msg string) {
+	var e HTTPError
+	var pe ProblemError
+	if errors.As(err, &e) {
+		code, header, msg = e.Status(), e.Header(), e.Message()
+	} else if errors.As(err, &pe) && pe.Problem() != nil && pe.Problem().Status != 0 {
+		code, msg = pe.Problem().Status, pe.Error()
+	} else {
+		code, msg = http.StatusBadRequest, err.Error()
+	}
 
 	if msg == "" {
 		msg = http.StatusText(code)
 	}
 
 	if msg == "" {
-		msg = "StatusError:" + strconv.Itoa(int(code))
+		msg = "StatusError:" + strconv.Itoa(code)
+	}
+	return
+}
+
+// HandleError 是 Rivet 缺省的错误处理方法.
+// 如果 err 实现了 HTTPError, 使用它的状态码, 响应头和公开消息.
+// 如果 err 实现了 ProblemError, 使用 Problem 的状态码. 否则响应 400 和 err.Error().
+func HandleError(err error, rw http.ResponseWriter, req *http.Request) {
+	if err == nil || err == io.EOF {
+		return
+	}
+
+	code, header, msg := statusOf(err)
+	for k, v := range header {
+		rw.Header()[k] = v
 	}
 
 	rw.WriteHeader(code)
@@ -0,0 +1,105 @@
+package rivet
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// In 用于嵌入到结构体中, 表示该结构体类型的 handler 参数以字段方式注入.
+// ToDispatcher 一次性分析结构体布局, 派发时新建结构体, 以 Context.Pick 逐个注入导出字段.
+// 字段可使用 tag 控制注入:
+//
+//   `rivet:"-"`         忽略该字段.
+//   `rivet:"optional"`  找不到时保持零值, 不终止派发. provider 返回错误时仍终止.
+//
+// 例如:
+//
+//   type Services struct {
+//       rivet.In
+//       DB    *sql.DB
+//       Log   *log.Logger
+//       Cache *Cache `rivet:"optional"`
+//   }
+//
+//   r.Get("/", func(s Services, c *rivet.Context) { ... })
+//
+// 这样依赖增加时 handler 的签名保持不变. 参数也可以是指向该结构体的指针.
+type In struct{}
+
+var typeIn = reflect.TypeOf(In{})
+
+// object 是 In 结构体参数的布局.
+type object struct {
+	typ    reflect.Type // 结构体类型
+	ptr    bool         // 参数是否为指针
+	fields []field
+}
+
+type field struct {
+	index    int
+	id       unsafe.Pointer
+	impl     unsafe.Pointer // 缓存接口类型字段的实现类型
+	optional bool
+}
+
+// newObject 返回 t 的布局, t 不是嵌入了 In 的结构体或其指针时返回 nil.
+func newObject(t reflect.Type) *object {
+	o := &object{typ: t}
+	if t.Kind() == reflect.Ptr {
+		o.typ, o.ptr = t.Elem(), true
+	}
+	if o.typ.Kind() != reflect.Struct {
+		return nil
+	}
+
+	embedded := false
+	for i := 0; i < o.typ.NumField(); i++ {
+		f := o.typ.Field(i)
+		if f.Anonymous && f.Type == typeIn {
+			embedded = true
+			continue
+		}
+
+		tag := f.Tag.Get("rivet")
+		if f.PkgPath != "" || tag == "-" {
+			continue
+		}
+		o.fields = append(o.fields, field{
+			index:    i,
+			id:       TypePointerOf(f.Type),
+			optional: tag == "optional",
+		})
+	}
+
+	if !embedded {
+		return nil
+	}
+	return o
+}
+
+// build 以 c 注入字段, 返回结构体或其指针. fn 用于报告无法注入的字段.
+func (o *object) build(c *Context, fn reflect.Value) (reflect.Value, bool) {
+	p := reflect.New(o.typ)
+	s := p.Elem()
+	for i := range o.fields {
+		f := &o.fields[i]
+		n := c.handled
+		v, ok := c.pick(f.id, o.typ.Field(f.index).Type, &f.impl)
+		if !ok {
+			// 查找期间处理过错误, 比如 provider 返回的错误, 即使是 optional 也终止
+			if f.optional && c.handled == n {
+				continue
+			}
+			c.Unresolved(funcName(fn), o.typ.Field(f.index).Type)
+			return p, false
+		}
+		if v != nil {
+			s.Field(f.index).Set(reflect.ValueOf(v))
+		}
+	}
+
+	if o.ptr {
+		return p, true
+	}
+	return s, true
+}
@@ -0,0 +1,146 @@
+package rivet
+
+import (
+	"net/http"
+	"unsafe"
+)
+
+// Injector 是可被多个请求共享的注入变量容器, 比如数据库连接池, 配置, 日志.
+// Context.Pick 在请求期关联的变量中找不到时, 依次在 Injector 及其父级中查找.
+// Injector 的方法不是并发安全的, 应在处理请求之前完成设置.
+type Injector struct {
+	parent    *Injector
+	values    map[unsafe.Pointer]interface{}
+	providers map[unsafe.Pointer]*provider
+}
+
+// NewInjector 返回以 parent 为父级的 *Injector, parent 可以为 nil.
+func NewInjector(parent *Injector) *Injector {
+	return &Injector{parent: parent}
+}
+
+// Parent 返回父级 Injector.
+func (i *Injector) Parent() *Injector {
+	return i.parent
+}
+
+// Map 等同 MapTo(v, v).
+func (i *Injector) Map(v interface{}) {
+	i.MapTo(v, v)
+}
+
+// MapTo 以 TypePointerOf(t) 为键值关联变量 v, 参见 Context.MapTo.
+func (i *Injector) MapTo(v interface{}, t interface{}) {
+	if i.values == nil {
+		i.values = make(map[unsafe.Pointer]interface{}, 1)
+	}
+	i.values[TypePointerOf(t)] = v
+}
+
+// Provide 注册 provider 函数 fn, 参见 Context.Provide.
+// provider 在每个请求中首次 Pick 时被调用, 结果只关联到该请求的 Context.
+func (i *Injector) Provide(fn interface{}) {
+	if i.providers == nil {
+		i.providers = make(map[unsafe.Pointer]*provider, 1)
+	}
+	p := newProvider(fn)
+	i.providers[p.out] = p
+}
+
+// Get 返回 i 及其父级中以类型指针 t 为键值关联的变量, 不调用 provider.
+func (i *Injector) Get(t unsafe.Pointer) (v interface{}, ok bool) {
+	for ; i != nil; i = i.parent {
+		if v, ok = i.values[t]; ok {
+			return
+		}
+	}
+	return
+}
+
+// pick 在 i 及其父级中查找 t, 优先返回关联的变量, 其次调用 provider.
+// 同一级中 values 优先于 providers, 子级优先于父级.
+func (i *Injector) pick(c *Context, t unsafe.Pointer) (interface{}, bool) {
+	for ; i != nil; i = i.parent {
+		if v, ok := i.values[t]; ok {
+			return v, true
+		}
+
+		if p := i.providers[t]; p != nil {
+			// 与 Context.Pick 相同, 以 c.providers 中的 nil 标记防止循环依赖
+			if c.providers == nil {
+				c.providers = make(map[unsafe.Pointer]*provider, 1)
+			}
+			if _, calling := c.providers[t]; calling {
+				return nil, false
+			}
+			c.providers[t] = nil
+			return p.call(c)
+		}
+	}
+	return nil, false
+}
+
+type dispatchScope struct {
+	i *Injector
+}
+
+func (d dispatchScope) IsInjector() bool                                     { return true }
+func (d dispatchScope) Hand(Params, http.ResponseWriter, *http.Request) bool { return true }
+func (d dispatchScope) Dispatch(c *Context) bool {
+	c.injector = d.i
+	return true
+}
+
+// Scope 返回一个 handler, 它把 Context 的 Injector 设置为 i, 通常 i 以路由所属 Rivet 的
+// Injector 为父级. Group 的 Map, MapTo, Provide 以此实现分组作用域.
+func Scope(i *Injector) Dispatcher {
+	return dispatchScope{i}
+}
+
+// Injector 返回 r 的 Injector, 它的变量对所有请求可见.
+func (r *Rivet) Injector() *Injector {
+	return r.injector
+}
+
+// Map 等同 r.Injector().Map(v).
+func (r *Rivet) Map(v interface{}) {
+	r.injector.Map(v)
+}
+
+// MapTo 等同 r.Injector().MapTo(v, t).
+func (r *Rivet) MapTo(v interface{}, t interface{}) {
+	r.injector.MapTo(v, t)
+}
+
+// Provide 等同 r.Injector().Provide(fn).
+func (r *Rivet) Provide(fn interface{}) {
+	r.injector.Provide(fn)
+}
+
+// Injector 返回 g 的 Injector, 首次调用时以上级分组或 Rivet 的 Injector 为父级创建.
+// 之后通过 g 注册的路由在派发时使用该 Injector, 因此应在注册路由之前调用.
+func (g *Group) Injector() *Injector {
+	if g.injector == nil {
+		if g.parent != nil {
+			g.injector = NewInjector(g.parent.Injector())
+		} else {
+			g.injector = NewInjector(g.rivet.injector)
+		}
+	}
+	return g.injector
+}
+
+// Map 等同 g.Injector().Map(v).
+func (g *Group) Map(v interface{}) {
+	g.Injector().Map(v)
+}
+
+// MapTo 等同 g.Injector().MapTo(v, t).
+func (g *Group) MapTo(v interface{}, t interface{}) {
+	g.Injector().MapTo(v, t)
+}
+
+// Provide 等同 g.Injector().Provide(fn).
+func (g *Group) Provide(fn interface{}) {
+	g.Injector().Provide(fn)
+}
@@ -0,0 +1,231 @@
+package rivet
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StatusNotAcceptable 表示没有客户端可接受的响应格式.
+const StatusNotAcceptable = StatusError(http.StatusNotAcceptable)
+
+// Encoder 把 handler 的返回值编码为 MediaType 格式, 用于内容协商.
+type Encoder struct {
+	MediaType   string                              // 用于匹配 Accept, 比如 "application/json"
+	ContentType string                              // 响应头 Content-Type, 为空时使用 MediaType
+	Encode      func(v interface{}) ([]byte, error) // 编码失败时尝试下一个可接受的 Encoder
+}
+
+// errUnsupported 表示 Encoder 不支持 v 的类型, 与其它编码错误不同, 它不被视为服务端错误.
+var errUnsupported = errors.New("unsupported type")
+
+var (
+	// JSONEncoder 以 encoding/json 编码.
+	JSONEncoder = &Encoder{"application/json", "application/json; charset=utf-8", json.Marshal}
+
+	// XMLEncoder 以 encoding/xml 编码.
+	XMLEncoder = &Encoder{"application/xml", "application/xml; charset=utf-8", xml.Marshal}
+
+	// TextEncoder 编码 string, encoding.TextMarshaler, fmt.Stringer, 不支持其它类型.
+	TextEncoder = &Encoder{"text/plain", "text/plain; charset=utf-8", encodeText}
+
+	// FormEncoder 编码 url.Values, map[string]string, map[string][]string,
+	// map[string]interface{}, Params 为 application/x-www-form-urlencoded.
+	FormEncoder = &Encoder{"application/x-www-form-urlencoded", "", encodeForm}
+)
+
+// Encoders 是参与内容协商的 Encoder, 排在前面的优先, 没有 Accept 头时使用第一个.
+var Encoders = []*Encoder{JSONEncoder, XMLEncoder, TextEncoder, FormEncoder}
+
+func encodeText(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		return []byte(v), nil
+	case encoding.TextMarshaler:
+		return v.MarshalText()
+	case fmt.Stringer:
+		return []byte(v.String()), nil
+	}
+	return nil, fmt.Errorf("rivet: can not encode %T as text: %w", v, errUnsupported)
+}
+
+func encodeForm(v interface{}) ([]byte, error) {
+	values := url.Values{}
+	switch v := v.(type) {
+	case url.Values:
+		values = v
+	case map[string][]string:
+		values = url.Values(v)
+	case map[string]string:
+		for k, s := range v {
+			values.Set(k, s)
+		}
+	case map[string]interface{}:
+		for k, s := range v {
+			values.Set(k, fmt.Sprint(s))
+		}
+	case Params:
+		v.AddTo(values)
+	default:
+		return nil, fmt.Errorf("rivet: can not encode %T as form: %w", v, errUnsupported)
+	}
+	return []byte(values.Encode()), nil
+}
+
+// produces 保存路由指定的 Encoder, 参见 Produces.
+type produces struct {
+	encoders []*Encoder
+}
+
+var idProduces = TypePointerOf([]*produces{})
+
+// Produces 返回一个 handler, 为所在路由指定参与内容协商的 Encoder, 替代 Encoders.
+// 参数 mediaType 必须是 Encoders 中已有的 MediaType, 否则产生 panic. 例如:
+//
+//   r.Get("/feed", rivet.Produces("application/xml"), feed)
+func Produces(mediaType ...string) Dispatcher {
+	p := &produces{}
+	for _, s := range mediaType {
+		var enc *Encoder
+		for _, e := range Encoders {
+			if e.MediaType == s {
+				enc = e
+				break
+			}
+		}
+		if enc == nil {
+			panic("rivet: unknown media type: " + s)
+		}
+		p.encoders = append(p.encoders, enc)
+	}
+	return dispatch{p}
+}
+
+// RenderNegotiate 以请求的 Accept 头选择 Encoder 输出 v, 是 DefaultRenderer 的缺省值.
+// 没有可接受的 Encoder 或者它们都不支持 v 的类型时返回 StatusNotAcceptable,
+// 其它编码错误以 500 状态码返回.
+func RenderNegotiate(c *Context, code int, v interface{}) error {
+	encoders := Encoders
+	if p, ok := c.Pick(idProduces); ok {
+		encoders = p.(*produces).encoders
+	}
+
+	accept := ""
+	if c.Req != nil {
+		accept = c.Req.Header.Get("Accept")
+	}
+
+	var first error
+	for _, e := range Negotiate(accept, encoders) {
+		b, err := e.Encode(v)
+		if err != nil {
+			if first == nil && !errors.Is(err, errUnsupported) {
+				first = err
+			}
+			continue
+		}
+
+		c.Res.Header().Add("Vary", "Accept")
+		ct := e.ContentType
+		if ct == "" {
+			ct = e.MediaType
+		}
+		writeBody(c, code, ct, b)
+		return nil
+	}
+
+	if first != nil {
+		return WithStatus(first, http.StatusInternalServerError)
+	}
+	return StatusNotAcceptable
+}
+
+// acceptRange 是 Accept 头中的一项.
+type acceptRange struct {
+	typ, sub string
+	q        float64
+}
+
+// parseAccept 解析 Accept 头, 忽略 q 之外的参数和格式错误的项.
+func parseAccept(accept string) []acceptRange {
+	var a []acceptRange
+	for _, s := range strings.Split(accept, ",") {
+		params := strings.Split(s, ";")
+		mt := strings.ToLower(strings.TrimSpace(params[0]))
+		i := strings.IndexByte(mt, '/')
+		if i <= 0 || i == len(mt)-1 {
+			continue
+		}
+
+		ar := acceptRange{typ: mt[:i], sub: mt[i+1:], q: 1}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if len(p) > 2 && (p[0] == 'q' || p[0] == 'Q') && p[1] == '=' {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q >= 0 && q <= 1 {
+					ar.q = q
+				}
+			}
+		}
+		a = append(a, ar)
+	}
+	return a
+}
+
+// quality 返回 ranges 对 mediaType 的 q 值, 使用最具体的匹配项. 没有匹配项时返回 0.
+func quality(ranges []acceptRange, mediaType string) float64 {
+	i := strings.IndexByte(mediaType, '/')
+	typ, sub := mediaType[:i], mediaType[i+1:]
+
+	q, best := 0.0, -1
+	for _, ar := range ranges {
+		specificity := -1
+		switch {
+		case ar.typ == typ && ar.sub == sub:
+			specificity = 2
+		case ar.typ == typ && ar.sub == "*":
+			specificity = 1
+		case ar.typ == "*" && ar.sub == "*":
+			specificity = 0
+		}
+
+		if specificity > best {
+			q, best = ar.q, specificity
+		}
+	}
+	return q
+}
+
+// accepts 返回 Accept 头 accept 是否接受 mediaType, 空 accept 接受任何类型.
+func accepts(accept, mediaType string) bool {
+	return accept == "" || quality(parseAccept(accept), mediaType) > 0
+}
+
+// Negotiate 返回 Accept 头 accept 可接受的 Encoder, 以 q 值从高到低排列,
+// q 值相同时保持 encoders 中的顺序. accept 为空时返回 encoders.
+func Negotiate(accept string, encoders []*Encoder) []*Encoder {
+	if accept == "" {
+		return encoders
+	}
+
+	ranges := parseAccept(accept)
+	qs := make(map[*Encoder]float64, len(encoders))
+	var a []*Encoder
+	for _, e := range encoders {
+		if q := quality(ranges, e.MediaType); q > 0 {
+			qs[e] = q
+			a = append(a, e)
+		}
+	}
+
+	sort.SliceStable(a, func(i, j int) bool {
+		return qs[a[i]] > qs[a[j]]
+	})
+	return a
+}
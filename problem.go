@@ -0,0 +1,145 @@
+package rivet
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// Problem 是 RFC 7807 定义的 problem details, 以 application/problem+json 输出.
+// *Problem 实现了 ProblemError, handler 或 Matcher 可以直接返回 *Problem.
+type Problem struct {
+	Type     string // 问题类型 URI, 缺省为 "about:blank"
+	Title    string // 问题类型的简短描述, 缺省为状态码对应的文本
+	Status   int    // HTTP 状态码
+	Detail   string // 本次问题的具体描述
+	Instance string // 本次问题的 URI, 缺省为请求的 URL.Path
+
+	// Extensions 保存扩展成员, 输出时与上述成员平级. 与上述成员同名的扩展被忽略.
+	Extensions map[string]interface{}
+}
+
+// ProblemError 是可以提供 problem details 的错误, HandleProblem 以其返回值为基础输出.
+type ProblemError interface {
+	error
+	Problem() *Problem
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Detail
+	}
+	if p.Title != "" {
+		return p.Title
+	}
+	return http.StatusText(p.Status)
+}
+
+// Problem 返回 p 自身, 实现 ProblemError.
+func (p *Problem) Problem() *Problem { return p }
+
+// MarshalJSON 实现 json.Marshaler, 输出扩展成员并忽略空的标准成员.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+
+	set := func(k, v string) {
+		if v != "" {
+			m[k] = v
+		} else {
+			delete(m, k)
+		}
+	}
+
+	set("type", p.Type)
+	set("title", p.Title)
+	set("detail", p.Detail)
+	set("instance", p.Instance)
+	if p.Status != 0 {
+		m["status"] = p.Status
+	} else {
+		delete(m, "status")
+	}
+	return json.Marshal(m)
+}
+
+// NewProblem 以 err 生成 *Problem. 如果 err 实现了 ProblemError, 以它的返回值为基础,
+// 否则以 HTTPError 提供的状态码和公开消息生成. 空成员被赋予缺省值.
+func NewProblem(err error, req *http.Request) *Problem {
+	code, _, msg := statusOf(err)
+
+	p := &Problem{}
+	var pe ProblemError
+	if errors.As(err, &pe) {
+		if base := pe.Problem(); base != nil {
+			*p = *base
+		}
+	}
+
+	if p.Status == 0 {
+		p.Status = code
+	}
+
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+
+	if p.Title == "" {
+		p.Title = http.StatusText(p.Status)
+	}
+
+	if p.Detail == "" && pe == nil && msg != p.Title {
+		p.Detail = msg
+	}
+
+	if p.Instance == "" && req != nil && req.URL != nil {
+		p.Instance = req.URL.Path
+	}
+	return p
+}
+
+// acceptJSON 返回 req 是否接受 JSON 响应. 没有 Accept 头也被视为接受.
+func acceptJSON(req *http.Request) bool {
+	if req == nil {
+		return true
+	}
+
+	accept := req.Header.Get("Accept")
+	return accepts(accept, "application/problem+json") || accepts(accept, "application/json")
+}
+
+// HandleProblem 是可替代 HandleError 的错误处理方法,
+// 以 application/problem+json 格式输出 NewProblem(err, req), 参见 RFC 7807.
+// 如果客户端不接受 JSON, 交由 HandleError 输出纯文本. 例如:
+//
+//   r := rivet.New()
+//   r.HandleError = rivet.HandleProblem
+func HandleProblem(err error, rw http.ResponseWriter, req *http.Request) {
+	if err == nil || err == io.EOF {
+		return
+	}
+
+	if !acceptJSON(req) {
+		HandleError(err, rw, req)
+		return
+	}
+
+	_, header, _ := statusOf(err)
+	for k, v := range header {
+		rw.Header()[k] = v
+	}
+
+	p := NewProblem(err, req)
+	b, e := json.Marshal(p)
+	if e != nil {
+		HandleError(err, rw, req)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.WriteHeader(p.Status)
+	rw.Write(b)
+}
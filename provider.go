@@ -0,0 +1,85 @@
+package rivet
+
+import (
+	"net/http"
+	"reflect"
+	"unsafe"
+)
+
+// provider 是延迟创建注入变量的函数, 参见 Provide.
+type provider struct {
+	fn  reflect.Value
+	in  []unsafe.Pointer
+	out unsafe.Pointer // 提供的类型
+}
+
+// newProvider 检查并包装 fn, 不符合要求时产生 panic.
+func newProvider(fn interface{}) *provider {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		panic("rivet: provider must be a func")
+	}
+
+	t := v.Type()
+	if t.IsVariadic() || t.NumOut() == 0 || t.NumOut() > 2 ||
+		t.NumOut() == 2 && TypePointerOf(t.Out(1)) != idError {
+		panic("rivet: provider must be func(...) T or func(...) (T, error), got " + t.String())
+	}
+
+	p := &provider{
+		fn:  v,
+		in:  make([]unsafe.Pointer, t.NumIn()),
+		out: TypePointerOf(t.Out(0)),
+	}
+	for i := range p.in {
+		p.in[i] = TypePointerOf(t.In(i))
+	}
+	return p
+}
+
+// call 以注入方式调用 p, 成功时以 p.out 为键值关联返回值到 c.
+// 参数无法注入或返回错误时交由 c.HandleError 处理并返回 false.
+func (p *provider) call(c *Context) (interface{}, bool) {
+	in := make([]reflect.Value, len(p.in))
+	for i, t := range p.in {
+		v, ok := c.Pick(t)
+		if !ok {
+			c.Unresolved(funcName(p.fn), p.fn.Type().In(i))
+			return nil, false
+		}
+		if v == nil {
+			in[i] = reflect.Zero(p.fn.Type().In(i))
+		} else {
+			in[i] = reflect.ValueOf(v)
+		}
+	}
+
+	out := p.fn.Call(in)
+	if len(out) == 2 && !out[1].IsNil() {
+		c.HandleError(out[1].Interface().(error))
+		return nil, false
+	}
+
+	v := out[0].Interface()
+	c.set(p.out, v)
+	return v, true
+}
+
+type dispatchProvide struct {
+	p *provider
+}
+
+func (d dispatchProvide) IsInjector() bool                                     { return true }
+func (d dispatchProvide) Hand(Params, http.ResponseWriter, *http.Request) bool { return true }
+func (d dispatchProvide) Dispatch(c *Context) bool {
+	c.provide(d.p)
+	return true
+}
+
+// Provide 返回一个 handler, 它把 fn 注册为 Context 的 provider, 参见 Context.Provide.
+// 不符合要求的 fn 会产生 panic. 例如:
+//
+//   r.Use(rivet.Provide(func(db *sql.DB) (*sql.Tx, error) { return db.Begin() }))
+func Provide(fn interface{}) Dispatcher {
+	return dispatchProvide{newProvider(fn)}
+}
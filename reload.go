@@ -0,0 +1,70 @@
+package rivet
+
+// table 是 Rivet 的路由表, 热更新时整体替换.
+type table struct {
+	router Router
+	routes Routes
+}
+
+func (r *Rivet) table() *table {
+	return r.tab.Load().(*table)
+}
+
+// clone 返回 tab 的深拷贝, 命名路由指向拷贝后的节点.
+func (tab *table) clone() *table {
+	nodes := make(map[*Trie]*Trie)
+	c := &table{
+		router: make(Router, len(tab.router)),
+		routes: make(Routes, len(tab.routes)),
+	}
+
+	for method, t := range tab.router {
+		c.router[method] = t.clone(nil, nodes)
+	}
+
+	for name, route := range tab.routes {
+		n := *route
+		n.Trie = nodes[route.Trie]
+		c.routes[name] = &n
+	}
+	return c
+}
+
+// Reload 以写时复制的方式热更新路由.
+// Reload 复制当前的路由表到一个新的 *Rivet, 以它为参数调用 fn,
+// fn 返回后用新路由表原子替换当前路由表.
+// 正在处理的请求继续使用旧路由表, 之后的请求使用新路由表.
+// 多个 Reload, Swap 调用是串行的.
+//
+// 并发处理请求时, 只能通过 Reload 或 Swap 修改路由, 直接调用 Handle, Remove
+// 等方法修改路由会产生数据竞争. 对 staged 的修改不会影响正在使用的路由表.
+//
+// fn 中只能通过 staged 注册路由, 分组也必须由 staged.Group 创建, 由 r 创建的 Group
+// 直接修改正在使用的路由表. staged 的中间件沿用 r 的, 调用 staged.Use 会产生 panic.
+func (r *Rivet) Reload(fn func(staged *Rivet)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	staged := &Rivet{
+		HandleError:   r.HandleError,
+		HandleOptions: r.HandleOptions,
+		Strict:        r.Strict,
+		Verify:        r.Verify,
+		Assignable:    r.Assignable,
+		middleware:    r.middleware,
+		injector:      r.injector,
+		staged:        true,
+	}
+	staged.tab.Store(r.table().clone())
+
+	fn(staged)
+	r.tab.Store(staged.table())
+}
+
+// Swap 原子替换 r 的路由表为 src 的路由表. 适用于完整重建路由的情况.
+// Swap 之后 src 与 r 共享路由表, 不应再修改 src 的路由.
+func (r *Rivet) Swap(src *Rivet) {
+	r.mu.Lock()
+	r.tab.Store(src.table())
+	r.mu.Unlock()
+}
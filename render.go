@@ -0,0 +1,116 @@
+package rivet
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"unsafe"
+)
+
+// Renderer 输出 handler 的返回值 v. code 非 0 时, 应在设置响应头之后以 code 调用 WriteHeader.
+// 返回的错误交由 Context.HandleError 处理, 因此应在写入响应之前完成可能失败的编码.
+// 不是 HTTPError 的错误被视为服务端错误, 以 500 响应.
+type Renderer func(c *Context, code int, v interface{}) error
+
+// Renderers 以 TypePointerOf 为键值保存 Renderer, 用于输出注入调用的 handler 的返回值.
+// 查找时使用返回值的动态类型. 内建的输出规则优先于 Renderers:
+//
+//   nil     不输出.
+//   bool    不输出, false 表示终止后续 handler.
+//   string  直接写入.
+//   []byte  直接写入.
+//
+// 其它没有注册的类型使用 DefaultRenderer 输出.
+var Renderers = map[unsafe.Pointer]Renderer{}
+
+// DefaultRenderer 是 Renderers 中未注册类型的输出方法, 缺省为 RenderNegotiate.
+var DefaultRenderer Renderer = RenderNegotiate
+
+// RegisterRenderer 以 TypePointerOf(reflect.TypeOf(v)) 为键值注册 fn 到 Renderers.
+func RegisterRenderer(v interface{}, fn Renderer) {
+	Renderers[TypePointerOf(reflect.TypeOf(v))] = fn
+}
+
+// RenderJSON 以 JSON 格式输出 v, 如果没有设置 Content-Type, 设置为 application/json.
+func RenderJSON(c *Context, code int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	writeBody(c, code, "application/json; charset=utf-8", b)
+	return nil
+}
+
+// writeBody 设置 Content-Type, 状态码, 然后写入 b.
+func writeBody(c *Context, code int, contentType string, b []byte) {
+	if contentType != "" && c.Res.Header().Get("Content-Type") == "" {
+		c.Res.Header().Set("Content-Type", contentType)
+	}
+	if code != 0 {
+		c.Res.WriteHeader(code)
+	}
+	c.Res.Write(b)
+}
+
+// serverError 把 Renderer 返回的普通错误包装为 500 Internal Server Error,
+// 已经是 HTTPError 或 ProblemError 的错误保持不变, 比如 StatusNotAcceptable.
+func serverError(err error) error {
+	var he HTTPError
+	var pe ProblemError
+	if errors.As(err, &he) || errors.As(err, &pe) {
+		return err
+	}
+	return WithStatus(err, http.StatusInternalServerError)
+}
+
+// Render 以注入调用处理 handler 返回值的规则输出 v, code 非 0 时作为响应状态码.
+// 返回 false 表示终止派发. 该方法供生成的 Dispatcher 等自定义注入调用使用.
+func (c *Context) Render(code int, v interface{}) bool {
+	return render(c, code, v)
+}
+
+// render 输出 handler 的返回值 v, code 非 0 时作为响应状态码. 返回 false 表示终止.
+func render(c *Context, code int, v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		if code != 0 {
+			c.Res.WriteHeader(code)
+		}
+	case bool:
+		if code != 0 {
+			c.Res.WriteHeader(code)
+		}
+		return v
+	case string:
+		if code != 0 {
+			c.Res.WriteHeader(code)
+		}
+		io.WriteString(c.Res, v)
+	case []byte:
+		if code != 0 {
+			c.Res.WriteHeader(code)
+		}
+		c.Res.Write(v)
+	case *http.Request:
+		// 以返回的 *http.Request 替换 c.Req, 比如 req.WithContext 的结果
+		if code != 0 {
+			c.Res.WriteHeader(code)
+		}
+		if v != nil {
+			c.setRequest(v)
+		}
+	default:
+		fn := Renderers[TypePointerOf(reflect.TypeOf(v))]
+		if fn == nil {
+			fn = DefaultRenderer
+		}
+
+		if err := fn(c, code, v); err != nil {
+			c.HandleError(serverError(err))
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,9 @@
 package rivet
 
 import (
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
 )
 
@@ -24,6 +27,34 @@ func (r *Response) Flush() {
 	}
 }
 
+// Hijack 实现 http.Hijacker 接口方法, 调用原 http.ResponseWriter 的 Hijack 方法.
+// 如果原 http.ResponseWriter 没有实现 http.Hijacker, 返回错误.
+func (r *Response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := r.w.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, errors.New("rivet: ResponseWriter does not implement http.Hijacker")
+}
+
+// Push 实现 http.Pusher 接口方法. 如果原 http.ResponseWriter 没有实现 http.Pusher,
+// 返回 http.ErrNotSupported.
+func (r *Response) Push(target string, opts *http.PushOptions) error {
+	if pusher, ok := r.w.(http.Pusher); ok {
+		return pusher.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap 返回原 http.ResponseWriter, 供 http.ResponseController 使用.
+func (r *Response) Unwrap() http.ResponseWriter {
+	return r.w
+}
+
+// Header 返回原 http.ResponseWriter 的 Header.
+func (r *Response) Header() http.Header {
+	return r.w.Header()
+}
+
 // WriteHeader 向相应发送状态码 s.
 func (r *Response) WriteHeader(s int) {
 	r.status = s
@@ -1,20 +1,60 @@
 package rivet
 
-import "net/http"
+import (
+	"log"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
 
 // Rivet 包装 Router, 实现了支持注入的 http.Handler.
 // Rivet 实现了 Dispatcher 接口, 并以 Handle 方法处理.
 type Rivet struct {
-	router      Router
+	tab        atomic.Value  // *table, 当前使用的路由表
+	mu         sync.Mutex    // 串行化 Reload, Swap
+	middleware []interface{} // Use 添加的 handler
+	use        Dispatcher    // middleware 的 Dispatcher 包装
+	injector   *Injector     // 所有请求共享的 Injector
+	staged     bool          // 是否为 Reload 中的 staged
+
 	HandleError func(error, http.ResponseWriter, *http.Request) // 处理路由匹配错误
+
+	// HandleOptions 非 nil 时, 自动响应没有注册路由的 OPTIONS 请求.
+	// 参数 allow 为 URL.Path 可匹配的 method, 已包含 "OPTIONS".
+	// 缺省为 nil, 可以设置为 HandleOptions 或者自定义方法, 比如处理 CORS 预检.
+	HandleOptions func(allow []string, rw http.ResponseWriter, req *http.Request)
+
+	// Recover 为 true 时, 处理请求时产生的 panic 被恢复并转换为 *PanicError,
+	// 交由 HandlePanic 处理. 如果 HandlePanic 为 nil, 在尚未写入响应时交由 HandleError 处理.
+	// http.ErrAbortHandler 不会被恢复.
+	Recover     bool
+	HandlePanic func(err *PanicError, rw http.ResponseWriter, req *http.Request)
+
+	// Strict 为 true 时, Handle 注册与已有路由冲突的 pattern 会产生 panic,
+	// panic 的值为 *ConflictError. 参见 Trie.Check.
+	Strict bool
+
+	// Verify 为 true 时, Handle 以 r.Injector() 对 Use 添加的中间件和 handler 调用 Verify,
+	// 注入参数无法被满足时产生 panic, panic 的值为 Verify 返回的错误.
+	Verify bool
+
+	// Assignable 为 true 时, 接口类型的注入参数在没有以该接口关联的变量时,
+	// 使用实现了该接口的关联变量, 参见 Context.PickAssignable.
+	// 请求期关联的变量每次都重新查找, 在 Injector 中找到的类型被缓存在 handler 中.
+	Assignable bool
 }
 
 // New 新建 *Rivet
 func New() *Rivet {
-	return &Rivet{
-		router:      map[string]*Trie{},
+	r := &Rivet{
 		HandleError: HandleError,
+		injector:    NewInjector(nil),
 	}
+	r.tab.Store(&table{router: Router{}, routes: Routes{}})
+	return r
 }
 
 // IsInjector 总是返回 false
@@ -25,22 +65,40 @@ func (r *Rivet) Dispatch(c *Context) bool { return true }
 
 // Hand 在处理请求时, 会把参数 args 和 req.URL.Path 匹配到的参数合并
 func (r *Rivet) Hand(args Params, rw http.ResponseWriter, req *http.Request) bool {
-	trie, params, err := r.router.Match(req.Method, req.URL.Path, req)
+	return r.serve(args, rw, req)
+}
 
-	if err != nil {
-		r.HandleError(err, rw, req)
-		return false
-	}
+// ServeHTTP 实现了 http.Handler 接口.
+func (r *Rivet) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	r.serve(nil, rw, req)
+}
 
-	if trie == nil {
-		r.HandleError(StatusNotFound, rw, req)
-		return false
+// serve 匹配路由, 执行 Use 添加的 handler, 然后派发路由或者处理匹配错误.
+func (r *Rivet) serve(args Params, rw http.ResponseWriter, req *http.Request) (ok bool) {
+	var d Dispatcher
+
+	if r.Recover {
+		res, is := rw.(*Response)
+		if !is {
+			res = NewResponse(rw)
+			rw = res
+		}
+		defer func() {
+			if v := recover(); v != nil {
+				r.handlePanic(v, res, req)
+				ok = false
+			}
+		}()
 	}
-	d, ok := trie.Word.(Dispatcher)
 
-	if !ok {
-		r.HandleError(StatusNotImplemented, rw, req)
-		return false
+	trie, params, err := r.table().router.Match(req.Method, req.URL.Path, req)
+
+	if err == nil {
+		if trie == nil {
+			err = StatusNotFound
+		} else if d, _ = trie.Word.(Dispatcher); d == nil {
+			err = StatusNotImplemented
+		}
 	}
 
 	if len(args) != 0 {
@@ -51,42 +109,98 @@ func (r *Rivet) Hand(args Params, rw http.ResponseWriter, req *http.Request) boo
 		}
 	}
 
-	if d.IsInjector() {
-		return d.Dispatch(&Context{Params: params, Res: rw, Req: req})
-	} else {
+	if r.use == nil {
+		if err != nil {
+			r.handleError(err, rw, req)
+			return false
+		}
+
+		if d.IsInjector() {
+			return d.Dispatch(&Context{Params: params, Res: rw, Req: req, handleError: r.HandleError, injector: r.injector, assignable: r.Assignable})
+		}
 		return d.Hand(params, rw, req)
 	}
+
+	c := &Context{Params: params, Res: rw, Req: req, handleError: r.HandleError, injector: r.injector, assignable: r.Assignable}
+	route := func() bool {
+		if err != nil {
+			c.MapTo(err, []error{})
+			r.handleError(err, c.Res, c.Req)
+			return false
+		}
+		return call(d, c)
+	}
+
+	// Defer 包装的 handler 在路由派发之后执行
+	if ds, ok := r.use.(dispatchs); ok {
+		return ds.around(c, route)
+	}
+
+	if !call(r.use, c) {
+		return false
+	}
+	return route()
 }
 
-// ServeHTTP 实现了 http.Handler 接口.
-func (r *Rivet) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	trie, params, err := r.router.Match(req.Method, req.URL.Path, req)
+// handlePanic 处理恢复的 panic 值 v. 如果已经写入了响应, 不再调用 HandleError,
+// 以免破坏已发送的部分响应, 此时以 log 输出 v 和调用栈.
+func (r *Rivet) handlePanic(v interface{}, res *Response, req *http.Request) {
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
 
-	if err != nil {
-		r.HandleError(err, rw, req)
-		return
+	err := &PanicError{Value: v, Stack: debug.Stack()}
+	if r.HandlePanic != nil {
+		r.HandlePanic(err, res, req)
+	} else if !res.Written() {
+		r.HandleError(err, res, req)
+	} else {
+		log.Printf("rivet: panic serving %s %s: %v\n%s", req.Method, req.URL.Path, v, err.Stack)
 	}
+}
 
-	if trie == nil {
-		r.HandleError(StatusNotFound, rw, req)
-		return
+// Use 添加在路由派发之前执行的 handler, 支持的类型同 ToDispatcher.
+// 这些 handler 对所有请求执行, 包括匹配失败的请求, 此时 Context.Params 可能为空.
+// 它们与路由 handler 共享同一个 Context, 如果其中一个返回 false, 后续处理被终止.
+// 使用 Defer 包装的 handler 在路由派发或者错误处理之后执行.
+// Reload 只替换路由表, 在 Reload 的 staged 上调用 Use 会产生 panic.
+func (r *Rivet) Use(handler ...interface{}) {
+	if r.staged {
+		panic("rivet: Use is not supported on the staged Rivet of Reload")
 	}
-	d, ok := trie.Word.(Dispatcher)
+	r.middleware = append(r.middleware, handler...)
+	r.use = ToDispatcher(r.middleware...)
+}
 
-	if !ok {
-		r.HandleError(StatusNotImplemented, rw, req)
+// handleError 处理路由匹配错误.
+// 如果设置了 HandleOptions, MethodNotAllowed 的 method 中会包含 "OPTIONS",
+// 且 OPTIONS 请求交由 HandleOptions 处理.
+func (r *Rivet) handleError(err error, rw http.ResponseWriter, req *http.Request) {
+	allow, ok := err.(MethodNotAllowed)
+	if !ok || r.HandleOptions == nil {
+		r.HandleError(err, rw, req)
 		return
 	}
 
-	if d.IsInjector() {
-		d.Dispatch(&Context{Params: params, Res: rw, Req: req})
+	allow = append(allow[:len(allow):len(allow)], "OPTIONS")
+	sort.Strings(allow)
+
+	if req.Method == "OPTIONS" {
+		r.HandleOptions(allow, rw, req)
 	} else {
-		d.Hand(params, rw, req)
+		r.HandleError(allow, rw, req)
 	}
 }
 
+// HandleOptions 是自动响应 OPTIONS 请求的缺省方法,
+// 设置响应头 "Allow" 并响应 204 No Content.
+func HandleOptions(allow []string, rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Allow", strings.Join(allow, ", "))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
 func (r *Rivet) Match(method, urlPath string, req *http.Request) (trie *Trie, params Params, err error) {
-	return r.router.Match(method, urlPath, req)
+	return r.table().router.Match(method, urlPath, req)
 }
 
 func (r *Rivet) Get(pattern string, handler ...interface{}) *Trie {
@@ -122,13 +236,82 @@ func (r *Rivet) Any(pattern string, handler ...interface{}) *Trie {
 }
 
 func (r *Rivet) Root(method string) *Trie {
-	return r.router[method]
+	return r.table().router[method]
+}
+
+// Remove 删除 method 中 pattern 对应的路由及其命名, 返回是否删除成功.
+func (r *Rivet) Remove(method, pattern string) bool {
+	if method == "*" {
+		method = "any"
+	}
+
+	tab := r.table()
+
+	var t *Trie
+	if root := tab.router[method]; root != nil {
+		t = root.Lookup(pattern)
+	}
+
+	if !tab.router.Remove(method, pattern) {
+		return false
+	}
+
+	for name, route := range tab.routes {
+		if route.Trie == t {
+			delete(tab.routes, name)
+		}
+	}
+	return true
 }
 
 // Handle 内部对 handler 进行了 Dispatcher 包装.
 // 这意味着返回的 Trie.Word 为 nil 或者 Dispatcher.
+// 如果 r.Strict 为 true, pattern 与已有路由冲突时产生 panic.
+// 如果 r.Verify 为 true, 注入参数无法被满足时产生 panic.
 func (r *Rivet) Handle(method string, pattern string, handler ...interface{}) *Trie {
-	t := r.router.Handle(method, pattern)
+	router := r.table().router
+	if r.Strict {
+		if err := router.Check(method, pattern); err != nil {
+			panic(err)
+		}
+	}
+	if r.Verify {
+		h := append(append([]interface{}{}, r.middleware...), handler...)
+		if err := verify(r.injector, r.Assignable, h); err != nil {
+			panic(err)
+		}
+	}
+
+	t := router.Handle(method, pattern)
 	t.Word = ToDispatcher(handler...)
 	return t
 }
+
+// Name 为路由节点 t 命名, 返回 t. t 必须是 r 注册过的路由节点.
+// 如果 name 已经存在, 产生 panic. 例如:
+//
+//   r.Name("user", r.Get("/user/:id uint", handler))
+func (r *Rivet) Name(name string, t *Trie) *Trie {
+	tab := r.table()
+	method := tab.router.Method(t)
+	if method == "" {
+		panic("rivet: trie not registered with route name: " + name)
+	}
+	tab.routes.Add(name, method, t)
+	return t
+}
+
+// Named 返回 name 对应的命名路由, 没有则返回 nil.
+func (r *Rivet) Named(name string) *Route {
+	return r.table().routes[name]
+}
+
+// Routes 返回所有的命名路由. 使用者不应该修改返回值.
+func (r *Rivet) Routes() Routes {
+	return r.table().routes
+}
+
+// URL 以 values 生成命名为 name 的路由的 URL.Path, 参见 Trie.Build.
+func (r *Rivet) URL(name string, values map[string]string) (string, error) {
+	return r.table().routes.URL(name, values)
+}
@@ -1,6 +1,22 @@
 package rivet
 
-import "testing"
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
 
 var routes = []string{
 	"/feeds",
@@ -309,6 +325,1129 @@ func TestTrie_Params(t *testing.T) {
 	}
 }
 
+func TestRivet_MethodNotAllowed(t *testing.T) {
+	r := New()
+	r.Post("/users", rivetHandler)
+	r.Get("/users", rivetHandler)
+	r.Delete("/users/:id", rivetHandler)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("PUT", "/users", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatal(rw.Code)
+	}
+	if allow := rw.Header().Get("Allow"); allow != "GET, HEAD, POST" {
+		t.Fatal(allow)
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/none", nil))
+	if rw.Code != http.StatusNotFound || rw.Header().Get("Allow") != "" {
+		t.Fatal(rw.Code, rw.Header())
+	}
+}
+
+func TestRivet_HandleOptions(t *testing.T) {
+	r := New()
+	r.Post("/users", rivetHandler)
+	r.Options("/users/:id", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	r.Put("/users/:id", rivetHandler)
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("OPTIONS", "/users", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Fatal(rw.Code)
+	}
+
+	r.HandleOptions = HandleOptions
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("OPTIONS", "/users", nil))
+	if rw.Code != http.StatusNoContent {
+		t.Fatal(rw.Code)
+	}
+	if allow := rw.Header().Get("Allow"); allow != "OPTIONS, POST" {
+		t.Fatal(allow)
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/users", nil))
+	if allow := rw.Header().Get("Allow"); allow != "OPTIONS, POST" {
+		t.Fatal(allow)
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("OPTIONS", "/users/1", nil))
+	if rw.Code != http.StatusOK {
+		t.Fatal(rw.Code)
+	}
+}
+
+func TestTrie_Build(t *testing.T) {
+	r := newTrie('/')
+	for i, s := range routes {
+		r.Mix(s).Word = i
+	}
+
+	values := map[string]string{
+		"id": "id", "owner": "owner", "repo": "repo", "user": "user",
+		"org": "org", "name": "name",
+	}
+
+	for i, s := range routes {
+		n := r.Node(urls[i])
+		switch s {
+		case "/hi/**":
+			values["**"] = "catch/All"
+		case "/:name/**":
+			values["**"] = "catchAll"
+		case "/:name/path/**":
+			values["**"] = "star/star"
+		case "/:name/path*/to":
+			values["*"] = "_Star"
+		case "/:name/*/to":
+			values["*"] = "star"
+		case `/just:id ^\d+$`:
+			values["id"] = "998"
+		case "/suffix**.go":
+			values["**"] = "/path/to"
+		case "/slash/?":
+			values["?"] = "/"
+		}
+
+		url, err := n.Build(values)
+		if err != nil || url != urls[i] {
+			t.Fatal(s, url, err)
+		}
+		delete(values, "*")
+		delete(values, "?")
+		values["id"] = "id"
+	}
+
+	if _, err := r.Node("/just998").Build(map[string]string{"id": "abc"}); err == nil {
+		t.Fatal("want an error")
+	}
+
+	if _, err := r.Node("/name").Build(map[string]string{"name": "a/b"}); err == nil {
+		t.Fatal("want an error")
+	}
+
+	url, _ := r.Node("/name").URL(Params{{Name: "name", Source: "a b"}})
+	if url != "/a%20b" {
+		t.Fatal(url)
+	}
+}
+
+func TestRivet_Name(t *testing.T) {
+	r := New()
+	r.Name("user", r.Get("/user/:id uint", rivetHandler))
+	r.Name("post", r.Post("/user/:id uint/post", rivetHandler))
+
+	route := r.Named("post")
+	if route == nil || route.Method != "POST" || route.Pattern != "/user/:id uint/post" {
+		t.Fatal(route)
+	}
+
+	url, err := r.URL("user", map[string]string{"id": "10"})
+	if url != "/user/10" || err != nil {
+		t.Fatal(url, err)
+	}
+
+	if _, err = r.URL("user", map[string]string{"id": "x"}); err == nil {
+		t.Fatal("want an error")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("want a panic with duplicate name")
+		}
+	}()
+	r.Name("user", r.Get("/users", rivetHandler))
+}
+
+func testRemove(t *testing.T, routes, urls []string) {
+	r := newTrie('/')
+	for _, s := range routes {
+		r.Mix(s).Word = s
+	}
+
+	for i, s := range routes {
+		if i%2 == 0 && !r.Remove(s) {
+			t.Fatal("remove", s)
+		}
+	}
+
+	for i, s := range urls {
+		n := r.Node(s)
+		if i%2 == 0 {
+			if n != nil && n.Word.(string) == routes[i] {
+				t.Fatal("removed", routes[i], s)
+			}
+			continue
+		}
+		if n == nil || n.Word.(string) != routes[i] || n.String() != routes[i] {
+			t.Fatal(routes[i], s, n)
+		}
+	}
+
+	for _, s := range routes {
+		r.Remove(s)
+	}
+	if len(r.childs) != 0 || r.pattern != "" {
+		r.Print()
+		t.Fatal("want an empty trie")
+	}
+
+	testTrie(t, r, r.Mix, routes, urls)
+}
+
+func TestTrie_Remove(t *testing.T) {
+	testRemove(t, routes, urls)
+	testRemove(t, staticRoutes, staticRoutes)
+}
+
+func TestRivet_Reload(t *testing.T) {
+	r := New()
+	r.Name("feeds", r.Get("/feeds", rivetHandler))
+
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, httptest.NewRequest("GET", "/feeds", nil))
+			if rw.Code != http.StatusOK {
+				t.Error(rw.Code)
+			}
+		}
+		close(done)
+	}()
+
+	for i := 0; i < 100; i++ {
+		r.Reload(func(staged *Rivet) {
+			staged.Remove("GET", "/feeds")
+			staged.Name("feeds", staged.Get("/feeds", rivetHandler))
+			for _, s := range routes {
+				staged.Get(s, rivetHandler)
+			}
+		})
+	}
+	<-done
+
+	if route := r.Named("feeds"); route == nil || route.Trie != r.Root("GET").Lookup("/feeds") {
+		t.Fatal(route)
+	}
+
+	r.Reload(func(staged *Rivet) {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("want a panic with staged.Use")
+			}
+		}()
+		staged.Use(rivetHandler)
+	})
+}
+
+func TestRivet_Walk(t *testing.T) {
+	r := New()
+	r.Name("repo", r.Get("/repos/:owner/:repo alnum", rivetHandler))
+	r.Post("/hi/**", http.NotFound)
+	r.Get("/feeds", rivetHandler, http.NotFound)
+
+	got := r.RouteInfos()
+	if len(got) != 3 || len(r.table().router.RouteInfos()) != 3 {
+		t.Fatal(got)
+	}
+
+	ri := got[1]
+	if ri.Name != "repo" || ri.Method != "GET" || ri.Pattern != "/repos/:owner/:repo alnum" ||
+		strings.Join(ri.Params, ",") != "owner,repo" ||
+		strings.Join(ri.Matchers, ",") != "string,alnum" ||
+		!strings.HasSuffix(ri.Handler, ".rivetHandler") {
+		t.Fatal(ri)
+	}
+
+	if ri = got[0]; ri.Pattern != "/feeds" ||
+		!strings.HasSuffix(ri.Handler, ".rivetHandler, net/http.NotFound") {
+		t.Fatal(ri)
+	}
+
+	if ri = got[2]; ri.Method != "POST" || len(ri.Params) != 1 || ri.Params[0] != "**" {
+		t.Fatal(ri)
+	}
+
+	var buf bytes.Buffer
+	r.Fprint(&buf)
+	if !strings.HasPrefix(buf.String(), "GET     /feeds  ") || strings.Count(buf.String(), "\n") != 3 {
+		t.Fatal(buf.String())
+	}
+}
+
+func TestTrie_Check(t *testing.T) {
+	r := newTrie('/')
+	for _, s := range routes {
+		if err := r.Check(s); err != nil {
+			t.Fatal(err)
+		}
+		r.Mix(s).Word = s
+	}
+
+	// 0 无冲突, 1 有歧义, 2 不可达
+	conflicts := map[string]int{
+		"/:id":                        2,
+		"/:title alpha":               2,
+		"/:name uint":                 2,
+		"/notifications/threads/:tid": 2,
+		"/hi/:id/to":                  2,
+		"/*":                          2,
+		"/hi/**.go":                   2,
+		"/:name/:x/to":                1,
+		"/hi/*":                       0,
+		"/users/new":                  0,
+		"/:name/path":                 0,
+		`/just:id ^\w+$`:              0,
+	}
+
+	for s, want := range conflicts {
+		got := 0
+		if err, ok := r.Check(s).(*ConflictError); ok {
+			got = 1
+			if err.Unreachable {
+				got = 2
+			}
+		}
+		if got != want {
+			t.Fatal(s, got, want)
+		}
+	}
+
+	rv := New()
+	rv.Strict = true
+	rv.Get("/:id", rivetHandler)
+	defer func() {
+		if _, ok := recover().(*ConflictError); !ok {
+			t.Fatal("want a panic with *ConflictError")
+		}
+	}()
+	rv.Get("/:name", rivetHandler)
+}
+
+func TestRivet_Group(t *testing.T) {
+	var trace []string
+	mark := func(s string) func(*Context) {
+		return func(*Context) { trace = append(trace, s) }
+	}
+
+	r := New()
+	admin := r.Group("/admin", mark("auth"))
+	admin.Get("/users", mark("users"))
+	api := admin.Group("/api", mark("api"))
+	api.Post("/users/:id", mark("user"))
+
+	if api.Prefix() != "/admin/api" {
+		t.Fatal(api.Prefix())
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin/users", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/admin/api/users/1", nil))
+
+	if s := strings.Join(trace, ","); s != "auth,users,auth,api,user" {
+		t.Fatal(s)
+	}
+}
+
+func TestRivet_Use(t *testing.T) {
+	var trace []string
+	r := New()
+	r.Use(func(c *Context) bool {
+		trace = append(trace, "use:"+c.Req.URL.Path)
+		c.Map("mapped")
+		return c.Req.URL.Path != "/deny"
+	})
+	r.Get("/deny", rivetHandler)
+	r.Get("/:name", func(s string, params Params) {
+		trace = append(trace, s+":"+params.Get("name"))
+	})
+
+	for _, path := range []string{"/hi", "/deny", "/a/b"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if s := strings.Join(trace, ","); s != "use:/hi,mapped:hi,use:/deny,use:/a/b" {
+		t.Fatal(s)
+	}
+}
+
+func TestDefer(t *testing.T) {
+	var trace []string
+	logger := func(res *Response, err error) {
+		if err != nil {
+			trace = append(trace, err.Error())
+		} else {
+			trace = append(trace, fmt.Sprint(res.Status()))
+		}
+	}
+
+	r := New()
+	r.Use(Defer(logger))
+	r.Get("/ok", Defer(func() { trace = append(trace, "after") }),
+		func(c *Context) bool { return false },
+		rivetHandler)
+	r.Get("/fail", func() error { return errors.New("fail") })
+
+	for _, path := range []string{"/ok", "/fail", "/none"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if s := strings.Join(trace, ","); s != "after,0,fail,Not Found" {
+		t.Fatal(s)
+	}
+}
+
+func TestDefer_Panic(t *testing.T) {
+	var got []error
+	rollback := func(err error) { got = append(got, err) }
+
+	r := New()
+	r.Recover = true
+	r.Use(Defer(rollback))
+	r.Get("/", Defer(rollback), func() { panic("boom") })
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusInternalServerError || len(got) != 2 {
+		t.Fatal(rw.Code, got)
+	}
+
+	for _, err := range got {
+		pe, ok := err.(*PanicError)
+		if !ok || pe.Value != "boom" || len(pe.Stack) == 0 {
+			t.Fatal(err)
+		}
+	}
+
+	defer func() {
+		if v := recover(); v != "boom" {
+			t.Fatal(v)
+		}
+	}()
+	ToDispatcher(Defer(rollback), func() { panic("boom") }).Dispatch(&Context{})
+}
+
+type hijacker struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (h *hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h.hijacked = true
+	return nil, nil, nil
+}
+
+func TestResponse_Unwrap(t *testing.T) {
+	h := &hijacker{ResponseRecorder: httptest.NewRecorder()}
+	r := New()
+	r.Recover = true
+	r.Get("/", func(rw http.ResponseWriter) error {
+		if _, ok := rw.(*Response); !ok {
+			return errors.New("not wrapped")
+		}
+		if _, _, err := http.NewResponseController(rw).Hijack(); err != nil {
+			return err
+		}
+		return nil
+	})
+
+	r.ServeHTTP(h, httptest.NewRequest("GET", "/", nil))
+	if !h.hijacked {
+		t.Fatal(h.Code, h.Body.String())
+	}
+
+	res := NewResponse(httptest.NewRecorder())
+	if _, _, err := res.Hijack(); err == nil {
+		t.Fatal("want an error")
+	}
+	if res.Push("/", nil) != http.ErrNotSupported {
+		t.Fatal("want ErrNotSupported")
+	}
+}
+
+func TestRivet_Recover(t *testing.T) {
+	r := New()
+	r.Recover = true
+	r.Get("/panic", func(params Params) { panic("boom") })
+	r.Get("/partial", func(rw http.ResponseWriter) {
+		rw.Write([]byte("partial"))
+		panic("boom")
+	})
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/panic", nil))
+	if rw.Code != http.StatusInternalServerError || rw.Body.String() != "Internal Server Error" {
+		t.Fatal(rw.Code, rw.Body.String())
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/partial", nil))
+	if rw.Code != http.StatusOK || rw.Body.String() != "partial" {
+		t.Fatal(rw.Code, rw.Body.String())
+	}
+	if s := buf.String(); !strings.Contains(s, "rivet: panic serving GET /partial: boom") ||
+		!strings.Contains(s, "goroutine") {
+		t.Fatal(s)
+	}
+
+	var got *PanicError
+	r.HandlePanic = func(err *PanicError, rw http.ResponseWriter, req *http.Request) {
+		got = err
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	if got == nil || got.Value != "boom" || len(got.Stack) == 0 {
+		t.Fatal(got)
+	}
+}
+
+func TestContext_HandleError(t *testing.T) {
+	r := New()
+	r.HandleError = func(err error, rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+		rw.Write([]byte("json:" + err.Error()))
+	}
+	r.Get("/fail", func() error { return errors.New("fail") })
+
+	hr := NewHostRouter()
+	hr.Add("example.com", r)
+
+	rw := httptest.NewRecorder()
+	hr.ServeHTTP(rw, httptest.NewRequest("GET", "http://example.com/fail", nil))
+	if rw.Code != http.StatusTeapot || rw.Body.String() != "json:fail" {
+		t.Fatal(rw.Code, rw.Body.String())
+	}
+}
+
+func TestHandleError(t *testing.T) {
+	errs := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("bad"), "400 bad"},
+		{StatusNotFound, "404 Not Found"},
+		{MethodNotAllowed{"GET"}, "405 Method Not Allowed GET"},
+		{&PanicError{Value: "secret"}, "500 Internal Server Error"},
+		{WithStatus(errors.New("exists"), http.StatusConflict), "409 exists"},
+		{WithHeader(WithStatus(errors.New("x"), http.StatusForbidden), "Allow", "POST"), "403 x POST"},
+		{fmt.Errorf("wrap: %w", WithStatus(errors.New("invalid"), 422)), "422 invalid"},
+		{WithStatus(errors.New("secret"), http.StatusBadGateway), "502 Bad Gateway"},
+		{WithHeader(StatusError(http.StatusUnauthorized), "Allow", "GET"), "401 Unauthorized GET"},
+		{WithHeader(secretError{}, "Allow", "GET"), "403 forbidden GET"},
+		{WithStatus(secretError{}, http.StatusNotFound), "404 forbidden"},
+	}
+
+	for _, e := range errs {
+		rw := httptest.NewRecorder()
+		HandleError(e.err, rw, nil)
+		got := strings.TrimSpace(fmt.Sprint(rw.Code, " ", rw.Body.String(), " ", rw.Header().Get("Allow")))
+		if got != e.want {
+			t.Fatal(got, e.want)
+		}
+	}
+}
+
+// secretError 的 Error 不应公开.
+type secretError struct{}
+
+func (secretError) Error() string       { return "internal secret" }
+func (secretError) Status() int         { return http.StatusForbidden }
+func (secretError) Header() http.Header { return nil }
+func (secretError) Message() string     { return "forbidden" }
+
+func TestHandleProblem(t *testing.T) {
+	r := New()
+	r.HandleError = HandleProblem
+	r.Get("/users/:id", func() error {
+		return &Problem{
+			Type:       "https://example.com/probs/out-of-credit",
+			Status:     http.StatusForbidden,
+			Detail:     "Your current balance is 30, but that costs 50.",
+			Extensions: map[string]interface{}{"balance": 30, "status": "ignored"},
+		}
+	})
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/users/1", nil))
+
+	var got map[string]interface{}
+	json.Unmarshal(rw.Body.Bytes(), &got)
+	if rw.Code != http.StatusForbidden ||
+		rw.Header().Get("Content-Type") != "application/problem+json" ||
+		got["title"] != "Forbidden" || got["status"] != 403.0 || got["balance"] != 30.0 ||
+		got["instance"] != "/users/1" || got["type"] != "https://example.com/probs/out-of-credit" {
+		t.Fatal(rw.Code, rw.Body.String())
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/none", nil))
+	if rw.Code != http.StatusNotFound ||
+		rw.Body.String() != `{"instance":"/none","status":404,"title":"Not Found","type":"about:blank"}` {
+		t.Fatal(rw.Code, rw.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/none", nil)
+	req.Header.Set("Accept", "text/plain")
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotFound || rw.Body.String() != "Not Found" {
+		t.Fatal(rw.Code, rw.Body.String())
+	}
+}
+
+type user struct {
+	Name string `json:"name"`
+}
+
+func TestRender(t *testing.T) {
+	r := New()
+	r.Get("/user", func() *user { return &user{"rivet"} })
+	r.Get("/created", func() (int, map[string]int) { return http.StatusCreated, map[string]int{"id": 1} })
+	r.Get("/fail", func() (*user, error) { return &user{"x"}, StatusNotFound })
+	r.Get("/text", func() (int, string, error) { return http.StatusAccepted, "text", nil })
+	r.Get("/custom", func() []user { return []user{{"a"}, {"b"}} })
+	r.Get("/broken", func() []named { return nil })
+
+	RegisterRenderer([]user{}, func(c *Context, code int, v interface{}) error {
+		for _, u := range v.([]user) {
+			c.WriteString(u.Name)
+		}
+		return nil
+	})
+	defer delete(Renderers, TypePointerOf(reflect.TypeOf([]user{})))
+	RegisterRenderer([]named{}, func(c *Context, code int, v interface{}) error {
+		return errors.New("template: secret detail")
+	})
+	defer delete(Renderers, TypePointerOf(reflect.TypeOf([]named{})))
+
+	for path, want := range map[string]string{
+		"/user":    `200 {"name":"rivet"}`,
+		"/created": `201 {"id":1}`,
+		"/fail":    `404 Not Found`,
+		"/text":    `202 text`,
+		"/custom":  `200 ab`,
+		"/broken":  "500 Internal Server Error",
+	} {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if got := fmt.Sprint(rw.Code, " ", rw.Body.String()); got != want {
+			t.Fatal(path, got)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	names := func(a []*Encoder) string {
+		s := make([]string, len(a))
+		for i, e := range a {
+			s[i] = e.MediaType
+		}
+		return strings.Join(s, ",")
+	}
+
+	for accept, want := range map[string]string{
+		"text/*;q=0.5, application/xml":       "application/xml,text/plain",
+		"*/*;q=0.1, application/json;q=0":     "application/xml,text/plain,application/x-www-form-urlencoded",
+		"application/*;q=0.3, text/plain;q=1": "text/plain,application/json,application/xml,application/x-www-form-urlencoded",
+		"image/png":                           "",
+	} {
+		if got := names(Negotiate(accept, Encoders)); got != want {
+			t.Fatal(accept, got)
+		}
+	}
+
+	r := New()
+	r.Get("/user", func() user { return user{"rivet"} })
+	r.Get("/feed", Produces("application/xml"), func() user { return user{"feed"} })
+	r.Get("/named", func() named { return "rivet" })
+	r.Get("/nan", func() map[string]float64 { return map[string]float64{"x": math.NaN()} })
+
+	for _, s := range []struct{ path, accept, want string }{
+		{"/user", "", `200 {"name":"rivet"}`},
+		{"/user", "application/xml", `200 <user><Name>rivet</Name></user>`},
+		{"/user", "text/plain", `406 Not Acceptable`},
+		{"/named", "text/plain", `200 rivet`},
+		{"/nan", "", `500 Internal Server Error`},
+		{"/nan", "application/json", `500 Internal Server Error`},
+		{"/user", "image/png", `406 Not Acceptable`},
+		{"/feed", "application/json, */*;q=0.1", `200 <user><Name>feed</Name></user>`},
+		{"/feed", "application/json", `406 Not Acceptable`},
+	} {
+		req := httptest.NewRequest("GET", s.path, nil)
+		if s.accept != "" {
+			req.Header.Set("Accept", s.accept)
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if got := fmt.Sprint(rw.Code, " ", rw.Body.String()); got != s.want {
+			t.Fatal(s.path, s.accept, got)
+		}
+	}
+}
+
+func TestProvide(t *testing.T) {
+	calls := 0
+	session := func(req *http.Request) (*user, error) {
+		calls++
+		if req.URL.Query().Get("name") == "" {
+			return nil, StatusError(http.StatusUnauthorized)
+		}
+		return &user{req.URL.Query().Get("name")}, nil
+	}
+
+	r := New()
+	r.Use(Provide(session))
+	r.Get("/lazy", func() string { return "lazy" })
+	r.Get("/user", func(u *user, c *Context) string {
+		v, _ := c.Pick(TypePointerOf(u))
+		return u.Name + v.(*user).Name
+	})
+
+	for _, s := range []struct{ path, want string }{
+		{"/lazy", "200 lazy"},
+		{"/user?name=a", "200 aa"},
+		{"/user", "401 Unauthorized"},
+	} {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", s.path, nil))
+		if got := fmt.Sprint(rw.Code, " ", rw.Body.String()); got != s.want {
+			t.Fatal(s.path, got)
+		}
+	}
+
+	if calls != 2 {
+		t.Fatal(calls)
+	}
+}
+
+func TestInjector(t *testing.T) {
+	r := New()
+	r.Map("app")
+	r.Map(1)
+
+	api := r.Group("/api")
+	api.Map("api")
+	v1 := api.Group("/v1")
+	v1.Map(2)
+
+	r.Get("/", func(s string, i int) string { return fmt.Sprint(s, i) })
+	api.Get("/", func(s string, i int) string { return fmt.Sprint(s, i) })
+	v1.Get("/", func(s string, i int) string { return fmt.Sprint(s, i) })
+	v1.Get("/override", func(c *Context) { c.Map("request") },
+		func(s string, i int) string { return fmt.Sprint(s, i) })
+
+	for path, want := range map[string]string{
+		"/":                "app1",
+		"/api/":            "api1",
+		"/api/v1/":         "api2",
+		"/api/v1/override": "request2",
+	} {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Body.String() != want {
+			t.Fatal(path, rw.Body.String())
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	type db struct{}
+	type tx struct{}
+
+	r := New()
+	r.Get("/missing", func(*db) {})
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/missing", nil))
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatal(rw.Code)
+	}
+
+	var ie *InjectError
+	err := Verify(nil, func(*Context, *db) {}, func(*tx, error) {})
+	if !errors.As(err, &ie) || len(ie.Types) != 1 ||
+		ie.Types[0] != reflect.TypeOf(&db{}) ||
+		!strings.Contains(err.Error(), "*rivet.tx, error") {
+		t.Fatal(err)
+	}
+
+	i := NewInjector(nil)
+	i.Map(&db{})
+	if err := Verify(i, Provide(func(*db) *tx { return nil }), func(*db, *tx) {},
+		Defer(func(*Response, error) {})); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Verify = true
+	r.Use(&db{})
+	r.Get("/", func(*db) {})
+	g := r.Group("/api")
+	g.Map(&tx{})
+	g.Get("/", func(*tx) {})
+
+	defer func() {
+		if !errors.As(recover().(error), &ie) {
+			t.Fatal(ie)
+		}
+	}()
+	r.Get("/tx", func(*tx) {})
+	t.Fatal("should panic")
+}
+
+func TestIn(t *testing.T) {
+	type services struct {
+		In
+		Name    string
+		Count   int
+		Skip    string `rivet:"-"`
+		Cache   *bool  `rivet:"optional"`
+		private string
+	}
+
+	r := New()
+	r.Map("rivet")
+	r.Provide(func() int { return 2 })
+	r.Get("/", func(s services) string {
+		return fmt.Sprint(s.Name, s.Count, s.Skip == "", s.Cache == nil)
+	})
+	r.Get("/ptr", func(s *services, c *Context) string { return s.Name })
+	r.Get("/missing", func(s struct {
+		In
+		DB *sql
+	}) {
+	})
+
+	for path, want := range map[string]string{
+		"/":    "rivet2 true true",
+		"/ptr": "rivet",
+	} {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Body.String() != want {
+			t.Fatal(path, rw.Body.String())
+		}
+	}
+
+	r.Get("/session", func(c *Context) {
+		c.Provide(func() (*user, error) { return nil, WithStatus(errors.New("no session"), 401) })
+	}, func(s struct {
+		In
+		User *user `rivet:"optional"`
+	}) string {
+		return "handler ran"
+	})
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/missing", nil))
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatal(rw.Code)
+	}
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/session", nil))
+	if rw.Code != 401 || strings.Contains(rw.Body.String(), "handler ran") {
+		t.Fatal(rw.Code, rw.Body.String())
+	}
+
+	err := Verify(r.Injector(), func(services) {}, func(struct {
+		In
+		DB *sql
+	}) {
+	})
+	var ie *InjectError
+	if !errors.As(err, &ie) || ie.Types[0] != reflect.TypeOf(&sql{}) {
+		t.Fatal(err)
+	}
+}
+
+type sql struct{}
+
+func TestToDispatcher_Injector(t *testing.T) {
+	// 没有 *Context 参数的注入调用也需要以 Dispatch 派发
+	d := ToDispatcher("rivet", func(s string) string { return s })
+	if !d.IsInjector() {
+		t.Fatal("want an injector")
+	}
+
+	r := New()
+	r.Get("/", "rivet", func(s string) string { return s })
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Body.String() != "rivet" {
+		t.Fatal(rw.Body.String())
+	}
+}
+
+func TestTyped(t *testing.T) {
+	r := New()
+	r.Map(named("rivet"))
+	r.Map(1)
+	r.Assignable = true
+	r.Verify = true
+
+	r.Get("/1", Func1(func(rw http.ResponseWriter) { rw.Write([]byte("1")) }))
+	r.Get("/e2", FuncE2(func(s named, i int) error { return WithStatus(errors.New(s.String()), i+400) }))
+	r.Get("/r3/:x", FuncR3(func(p Params, s fmt.Stringer, i int) string { return p.Get("x") + s.String() + fmt.Sprint(i) }))
+	r.Get("/re6", FuncRE6(func(a named, b int, c *Context, d Params, e struct {
+		In
+		N named
+	}, f *http.Request) (*user, error) {
+		return &user{Name: string(e.N) + f.URL.Path}, nil
+	}))
+	if err := Verify(r.Injector(), Func1(func(*user) {})); err == nil {
+		t.Fatal("want InjectError")
+	}
+	r.Verify = false
+	r.Get("/missing", "x", Func1(func(*user) {}))
+
+	for path, want := range map[string]string{
+		"/1":       "1",
+		"/e2":      "rivet",
+		"/r3/x":    "xrivet1",
+		"/re6":     `{"name":"rivet/re6"}`,
+		"/missing": "Internal Server Error",
+	} {
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if strings.TrimSpace(rw.Body.String()) != want {
+			t.Fatal(path, rw.Code, rw.Body.String())
+		}
+	}
+	if !strings.HasSuffix(Describe(Func1(rivetHandler)), ".rivetHandler") {
+		t.Fatal(Describe(Func1(rivetHandler)))
+	}
+}
+
+// namedDispatcher 模拟 rivetgen 为 namedHandler 生成的代码.
+type namedDispatcher struct{}
+
+func namedHandler(s named) string { return "generated " + s.String() }
+
+func (namedDispatcher) Func() interface{}                                    { return namedHandler }
+func (namedDispatcher) IsInjector() bool                                     { return true }
+func (namedDispatcher) Hand(Params, http.ResponseWriter, *http.Request) bool { return true }
+func (namedDispatcher) Dispatch(c *Context) bool {
+	v, ok := c.Pick(TypePointerOf(named("")))
+	if !ok {
+		c.Unresolved("rivet.namedHandler", reflect.TypeOf(named("")))
+		return false
+	}
+	return c.Render(0, namedHandler(v.(named)))
+}
+
+// stringerDispatcher 模拟生成的代码, 接口类型参数不按 Assignable 查找.
+type stringerDispatcher struct{ namedDispatcher }
+
+func stringerHandler(s fmt.Stringer) string { return s.String() }
+
+func (stringerDispatcher) Func() interface{} { return stringerHandler }
+
+// inDispatcher 模拟生成的代码, In 结构体参数不展开字段.
+type inDispatcher struct{ namedDispatcher }
+
+func inHandler(s struct {
+	In
+	N named
+}) string {
+	return s.N.String()
+}
+
+func (inDispatcher) Func() interface{} { return inHandler }
+
+func TestRegisterDispatcher(t *testing.T) {
+	RegisterDispatcher(namedDispatcher{})
+	RegisterDispatcher(stringerDispatcher{})
+	RegisterDispatcher(inDispatcher{})
+	defer delete(generated, reflect.ValueOf(namedHandler).Pointer())
+
+	for _, fn := range []interface{}{stringerHandler, inHandler} {
+		if _, ok := ToDispatcher(fn).(dispatcher); !ok {
+			t.Fatal(Describe(fn), "should be dispatched by reflection")
+		}
+	}
+	i := NewInjector(nil)
+	i.Map(named("rivet"))
+	if err := verify(i, true, []interface{}{stringerHandler, inHandler}); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(i, true, []interface{}{stringerDispatcher{}, inDispatcher{}}); err == nil {
+		t.Fatal("want InjectError")
+	}
+
+	if _, ok := ToDispatcher(namedHandler).(namedDispatcher); !ok {
+		t.Fatal(ToDispatcher(namedHandler))
+	}
+	if !strings.HasSuffix(Describe(namedDispatcher{}), ".namedHandler") {
+		t.Fatal(Describe(namedDispatcher{}))
+	}
+	var ie *InjectError
+	if err := Verify(nil, namedHandler); !errors.As(err, &ie) || ie.Types[0] != reflect.TypeOf(named("")) {
+		t.Fatal(err)
+	}
+
+	r := New()
+	r.Map(named("rivet"))
+	r.Get("/", namedHandler)
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Body.String() != "generated rivet" {
+		t.Fatal(rw.Body.String())
+	}
+}
+
+func TestContext_Context(t *testing.T) {
+	type key struct{}
+
+	r := New()
+	r.Map(named("rivet"))
+	r.Get("/", func(c *Context) {
+		c.WithContext(context.WithValue(c.Context(), key{}, "value"))
+	}, func(ctx context.Context, req *http.Request) string {
+		if req.Context().Value(key{}) != "value" {
+			return "request not updated"
+		}
+		return fmt.Sprint(ctx.Value(key{}), ctx.Value(TypePointerOf(named(""))), ctx.Err())
+	})
+
+	r.Get("/req", func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), key{}, "req"))
+	}, FuncR1(func(req *http.Request) *http.Request {
+		return req.WithContext(context.WithValue(req.Context(), key{}, req.Context().Value(key{}).(string)+"req"))
+	}), func(c *Context, req *http.Request) string {
+		return fmt.Sprint(c.Req == req, req.Context().Value(key{}), c.Context().Value(key{}))
+	})
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Body.String() != "valuerivet<nil>" {
+		t.Fatal(rw.Body.String())
+	}
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/req", nil))
+	if rw.Body.String() != "truereqreqreqreq" {
+		t.Fatal(rw.Body.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := &Context{Req: httptest.NewRequest("GET", "/", nil).WithContext(ctx)}
+	if c.Context().Err() != context.Canceled {
+		t.Fatal(c.Context().Err())
+	}
+	if v, _ := c.Pick(TypePointerOf([]context.Context{})); v.(context.Context).Err() != context.Canceled {
+		t.Fatal(v)
+	}
+}
+
+func TestContext_Value(t *testing.T) {
+	type key struct{}
+
+	r := New()
+	r.Map(named("rivet"))
+	r.Get("/", func(c *Context, ctx context.Context) string {
+		c.Provide(func() *user { panic("provider called by Value") })
+
+		done := make(chan struct{})
+		got := make(chan interface{})
+		go func() {
+			for {
+				select {
+				case <-done:
+					got <- ctx.Value(TypePointerOf([]int{}))
+					return
+				default:
+					ctx.Value(TypePointerOf([]int{}))
+					ctx.Value(TypePointerOf(named("")))
+					ctx.Value(TypePointerOf([]*http.Request{}))
+					ctx.Value(TypePointerOf(&user{}))
+				}
+			}
+		}()
+		for i := 0; i < 100; i++ {
+			c.Map(i)
+			c.WithContext(context.WithValue(c.Context(), key{}, i))
+		}
+		close(done)
+		return fmt.Sprint(<-got, ctx.Value(TypePointerOf(named(""))), ctx.Value(TypePointerOf(&user{})))
+	})
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Body.String() != "99rivet<nil>" {
+		t.Fatal(rw.Body.String())
+	}
+}
+
+type named string
+
+func (n named) String() string { return string(n) }
+
+func TestAssignable(t *testing.T) {
+	r := New()
+	r.Map(named("rivet"))
+	r.Get("/", func(s fmt.Stringer) string { return s.String() })
+	r.Get("/in", func(s struct {
+		In
+		S fmt.Stringer
+	}) string {
+		return s.S.String()
+	})
+	r.Get("/request", func(c *Context) { c.Map(&user{Name: "user"}) },
+		func(s fmt.Stringer) string { return s.String() })
+	r.Get("/ambiguous", func(c *Context) { c.Map(&user{}); c.Map(named("")) },
+		func(fmt.Stringer) {})
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if rw.Code != http.StatusInternalServerError {
+		t.Fatal(rw.Code)
+	}
+
+	r.Assignable = true
+	r.Verify = true
+	r.Get("/verify", func(fmt.Stringer) {})
+
+	type zA struct{ named }
+	type zB struct{ named }
+	r.Get("/probe", func(c *Context) {
+		c.Map(zA{"A"})
+		if c.Req.URL.Query().Get("b") != "" {
+			c.Map(zB{"B"})
+		}
+	}, func(s fmt.Stringer) string { return s.String() })
+	for i, path := range []string{"/probe?b=1", "/probe", "/probe?b=1", "/probe", "/"} {
+		want := []string{"Internal Server Error", "A", "Internal Server Error", "A", "rivet"}[i]
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Body.String() != want {
+			t.Fatal(path, rw.Code, rw.Body.String())
+		}
+	}
+
+	for _, path := range []string{"/", "/in", "/", "/request", "/ambiguous"} {
+		want := map[string]string{"/request": "user", "/ambiguous": "Internal Server Error"}[path]
+		if want == "" {
+			want = "rivet"
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Body.String() != want {
+			t.Fatal(path, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func (u *user) String() string { return u.Name }
+
 func rivetHandler(c *Context) {}
 
 func BenchmarkRivet_Static(b *testing.B) {
@@ -328,6 +1467,27 @@ func BenchmarkRivet_Static(b *testing.B) {
 	}
 }
 
+func benchmarkDispatch(b *testing.B, d Dispatcher) {
+	c := &Context{}
+	c.Map("rivet")
+	c.Map(1)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		d.Dispatch(c)
+	}
+}
+
+func BenchmarkDispatch_Reflect(b *testing.B) {
+	benchmarkDispatch(b, ToDispatcher(func(s string, i int, c *Context) error { return nil }))
+}
+
+func BenchmarkDispatch_Typed(b *testing.B) {
+	benchmarkDispatch(b, FuncE3(func(s string, i int, c *Context) error { return nil }))
+}
+
 func BenchmarkTrie_Static(b *testing.B) {
 	r := newTrie('/')
 
@@ -0,0 +1,62 @@
+package rivet
+
+import "errors"
+
+// Route 描述一个命名路由.
+type Route struct {
+	Name    string // 路由名
+	Method  string // HTTP method
+	Pattern string // 路由的完整 pattern
+	Trie    *Trie  // 路由终端节点
+}
+
+// URL 调用 Trie.Build 生成路由的 URL.Path.
+func (r *Route) URL(values map[string]string) (string, error) {
+	return r.Trie.Build(values)
+}
+
+// Routes 是以路由名为键值的命名路由索引, Rivet 以它保存命名路由.
+// Router 是 map 类型, 无法附加索引字段, 直接使用 Router 时以独立的 Routes 作为索引:
+//
+//   router := rivet.Router{}
+//   routes := rivet.Routes{}
+//   routes.Add("user", "GET", router.Get("/user/:id", handler))
+//   routes.URL("user", map[string]string{"id": "1"})
+//
+// 配合 Router.Method 可以由路由节点得到 method.
+type Routes map[string]*Route
+
+// Add 添加命名路由, 返回生成的 *Route.
+// 如果 name 为空或者已经存在, 产生 panic.
+func (rs Routes) Add(name, method string, t *Trie) *Route {
+	if name == "" {
+		panic("rivet: empty route name")
+	}
+
+	if t == nil {
+		panic("rivet: nil trie with route name: " + name)
+	}
+
+	if r := rs[name]; r != nil {
+		panic("rivet: duplicate route name: " + name + ", " +
+			r.Method + " " + r.Pattern)
+	}
+
+	r := &Route{Name: name, Method: method, Pattern: t.String(), Trie: t}
+	rs[name] = r
+	return r
+}
+
+// Get 返回 name 对应的命名路由, 没有则返回 nil.
+func (rs Routes) Get(name string) *Route {
+	return rs[name]
+}
+
+// URL 以 values 生成命名为 name 的路由的 URL.Path, 参见 Trie.Build.
+func (rs Routes) URL(name string, values map[string]string) (string, error) {
+	r := rs[name]
+	if r == nil {
+		return "", errors.New("rivet: unknown route name: " + name)
+	}
+	return r.URL(values)
+}
@@ -2,6 +2,7 @@ package rivet
 
 import (
 	"net/http"
+	"sort"
 	"strings"
 )
 
@@ -17,6 +18,8 @@ func echo(i interface{}) interface{} {
 //
 //   在 "HEAD" 方法中匹配不到时, 尝试在 "GET" 方法中匹配.
 //   最后尝试在 "any" 方法中匹配.
+//   如果仍然匹配不到, 但 urlPath 可在其它 method 中匹配到,
+//   返回的 err 为 MethodNotAllowed, 包含这些 method.
 //
 // 参数:
 //
@@ -50,9 +53,49 @@ func (r Router) Match(method, urlPath string, req *http.Request) (t *Trie, param
 			t, params, err = t.Match(urlPath, req)
 		}
 	}
+
+	if err == nil && t == nil {
+		if allow := r.allowed(method, urlPath, req); len(allow) != 0 {
+			err = allow
+		}
+	}
 	return
 }
 
+// allowed 返回 method 之外可匹配 urlPath 的 method 列表, 已排序.
+// 如果 "GET" 可匹配, "HEAD" 也被包含在内.
+func (r Router) allowed(method, urlPath string, req *http.Request) MethodNotAllowed {
+	var allow MethodNotAllowed
+	hasHead := false
+
+	for m, t := range r {
+		if m == method || m == "any" || t == nil {
+			continue
+		}
+
+		if n, _, err := t.Match(urlPath, req); n == nil || err != nil {
+			continue
+		}
+
+		allow = append(allow, m)
+		if m == "HEAD" {
+			hasHead = true
+		}
+	}
+
+	if !hasHead && method != "HEAD" {
+		for _, m := range allow {
+			if m == "GET" {
+				allow = append(allow, "HEAD")
+				break
+			}
+		}
+	}
+
+	sort.Strings(allow)
+	return allow
+}
+
 // Get 为 HTTP GET request 设置路由
 func (r Router) Get(pattern string, handler ...interface{}) *Trie {
 	return r.Handle("GET", pattern, handler...)
@@ -98,6 +141,42 @@ func (r Router) Root(method string) *Trie {
 	return r[method]
 }
 
+// Remove 删除 method 中 pattern 对应的路由, 返回是否删除成功.
+// 参数 method 为 "*" 等效 "any". 再次调用 Handle 即可替换路由.
+func (r Router) Remove(method, pattern string) bool {
+	if method == "*" {
+		method = "any"
+	}
+
+	t := r[method]
+	if t == nil || !t.Remove(pattern) {
+		return false
+	}
+
+	if t.Word == nil && len(t.childs) == 0 {
+		delete(r, method)
+	}
+	return true
+}
+
+// Method 返回路由节点 t 所属的 method, 如果 t 不属于 r 返回 "".
+func (r Router) Method(t *Trie) string {
+	if t == nil {
+		return ""
+	}
+
+	for t.parent != nil {
+		t = t.parent
+	}
+
+	for method, root := range r {
+		if root == t {
+			return method
+		}
+	}
+	return ""
+}
+
 // Handle 为 HTTP method request 设置路由的通用形式.
 // 参数 method 为 "*" 等效 "any". 其它值不做处理, 直接和 http.Request.Method 比较.
 func (r Router) Handle(method string, pattern string, handler ...interface{}) *Trie {
@@ -111,7 +190,8 @@ func (r Router) Handle(method string, pattern string, handler ...interface{}) *T
 		r[method] = t
 	}
 
-	trie := t.Mix(pattern)
+	// 根节点总是无前缀的分组节点, 以免返回的节点在重构时被改变.
+	trie := t.Add(pattern)
 
 	switch len(handler) {
 	case 0:
@@ -168,7 +248,7 @@ func (r *HostRouter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	if d.IsInjector() {
-		d.Dispatch(&Context{Params: params, Res: rw, Req: req})
+		d.Dispatch(&Context{Params: params, Res: rw, Req: req, handleError: r.HandleError})
 	} else {
 		d.Hand(params, rw, req)
 	}
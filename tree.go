@@ -83,6 +83,7 @@ func (t *Trie) Mix(path string) *Trie {
 
 // Merge 合并 path 到 t, Trie 树可能会被重构, 返回 path 终端节点.
 // 如果 t 未添加过 path, Merge 后 t 会被赋予一个路由 pattern.
+// 重构时非根节点保持不变, 但根节点的 Word 可能被移至新的子节点.
 // 如果参数 path 非法, 可能会产生 panic, 参数 build 用于生成 path 中的匹配器.
 //
 // TIP:
@@ -297,16 +298,37 @@ func (t *Trie) merge(path string) *Trie {
 	// 分割 t
 	if i != 0 && i < len(t.pattern) {
 
-		n := newTrie(t.sep)
-		n.parent = t
-		n.pattern, t.pattern = t.pattern[i:], t.pattern[:i]
-
-		n.Word, t.Word = t.Word, nil
-		n.childs, t.childs = t.childs, []*Trie{n}
-
-		n.offset, n.kind, t.offset = t.offset, t.kind, 1
-		n.nop = t.nop
+		if t.parent != nil {
+			// 插入前缀节点, 保持 t 不变, 因为 t 可能被使用者引用.
+			n := newTrie(t.sep)
+			n.parent = t.parent
+			n.pattern, t.pattern = t.pattern[:i], t.pattern[i:]
+			n.childs = []*Trie{t}
+			n.offset, n.kind, n.nop = 1, 0xff, t.nop
+
+			for j, c := range n.parent.childs {
+				if c == t {
+					n.parent.childs[j] = n
+					break
+				}
+			}
+			t.parent = n
+			t = n
+		} else {
+			// 根节点只能把自身内容移到新的子节点.
+			n := newTrie(t.sep)
+			n.parent = t
+			n.pattern, t.pattern = t.pattern[i:], t.pattern[:i]
+
+			n.Word, t.Word = t.Word, nil
+			n.childs, t.childs = t.childs, []*Trie{n}
+			for _, c := range n.childs {
+				c.parent = n
+			}
 
+			n.offset, n.kind, t.offset = t.offset, t.kind, 1
+			n.nop = t.nop
+		}
 	}
 
 	path = path[i:]
@@ -347,6 +369,120 @@ func (t *Trie) addChild(path string) *Trie {
 	return n
 }
 
+// Lookup 返回 path 对应的节点, 没有则返回 nil. path 与 Mix, Merge 使用的 path 相同,
+// 是 pattern 而非 URL.Path. 与 Match 不同, Lookup 不进行匹配, 也不检查 Word.
+func (t *Trie) Lookup(path string) *Trie {
+	if t.parent != nil {
+		path = t.parent.String() + path
+	}
+	return t.lookup(path)
+}
+
+func (t *Trie) lookup(path string) *Trie {
+	if !strings.HasPrefix(path, t.pattern) {
+		return nil
+	}
+
+	path = path[len(t.pattern):]
+	if path == "" {
+		return t
+	}
+
+	for _, c := range t.childs {
+		if n := c.lookup(path); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// Remove 删除 path 对应的路由, 返回是否删除成功. path 参见 Lookup.
+// Remove 清除节点的 Word, 移除不再需要的节点, 并重新合并被分割的定值节点.
+// 根节点不会被移除.
+func (t *Trie) Remove(path string) bool {
+	n := t.Lookup(path)
+	if n == nil || n.Word == nil {
+		return false
+	}
+
+	n.Word = nil
+
+	// 移除空节点
+	for n.parent != nil && n.Word == nil && len(n.childs) == 0 {
+		p := n.parent
+		p.removeChild(n)
+		n = p
+	}
+
+	if n.parent == nil {
+		if n.Word == nil && len(n.childs) == 0 {
+			n.pattern, n.kind, n.offset = "", 0, 0
+		}
+		return true
+	}
+
+	// 重新合并定值节点, 保留子节点的实例, 因为它可能被使用者引用.
+	if n.kind == 0xff && n.Word == nil && len(n.childs) == 1 &&
+		n.childs[0].kind == 0xff {
+
+		c, p := n.childs[0], n.parent
+		c.pattern = n.pattern + c.pattern
+		c.parent = p
+		for i := range p.childs {
+			if p.childs[i] == n {
+				p.childs[i] = c
+				break
+			}
+		}
+		n.parent, n.childs = nil, nil
+	}
+
+	return true
+}
+
+// removeChild 从 t.childs 中移除 n, 并修正 offset.
+func (t *Trie) removeChild(n *Trie) {
+	for i, c := range t.childs {
+		if c != n {
+			continue
+		}
+
+		copy(t.childs[i:], t.childs[i+1:])
+		t.childs[len(t.childs)-1] = nil
+		t.childs = t.childs[:len(t.childs)-1]
+
+		if i < int(t.offset) {
+			t.offset--
+		}
+		n.parent = nil
+		return
+	}
+}
+
+// Clone 返回 t 及其子节点的深拷贝, 拷贝为根节点.
+// Word 和 Matcher 被共享而不是复制.
+func (t *Trie) Clone() *Trie {
+	return t.clone(nil, nil)
+}
+
+// clone 复制 t 及其子节点, 如果 nodes 非 nil, 记录原节点到新节点的映射.
+func (t *Trie) clone(parent *Trie, nodes map[*Trie]*Trie) *Trie {
+	n := *t
+	n.parent = parent
+
+	if nodes != nil {
+		nodes[t] = &n
+	}
+
+	if t.childs != nil {
+		n.childs = make([]*Trie, len(t.childs))
+		for i, c := range t.childs {
+			n.childs[i] = c.clone(&n, nodes)
+		}
+	}
+	return &n
+}
+
 // Print 输出 Trie 结构信息到 os.Stdout.
 func (t *Trie) Print() {
 	t.Fprint(os.Stdout)
@@ -357,7 +493,7 @@ func (t *Trie) Fprint(w io.Writer) {
 	if w == nil {
 		return
 	}
-	fmt.Fprintln(w, "word kind offset nop pattern\n")
+	fmt.Fprint(w, "word kind offset nop pattern\n\n")
 	t.output(w, 0)
 }
 
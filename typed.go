@@ -0,0 +1,412 @@
+package rivet
+
+import "reflect"
+
+// typed 是泛型适配器返回的 Dispatcher, 参见 Func1.
+// 参数布局与 dispatcher 相同, 派发时以 Context.Pick 取得参数, 不使用 reflect.Value.Call.
+type typed struct {
+	dispatcher
+	call func(c *Context) bool
+}
+
+func (t *typed) Dispatch(c *Context) bool { return t.call(c) }
+
+func newTyped(fn interface{}) *typed {
+	return &typed{dispatcher: newDispatcher(reflect.ValueOf(fn))}
+}
+
+// arg 以注入调用的规则取得 d 的第 i 个参数, 包括 In 结构体, Rivet.Assignable 和错误处理.
+func arg[T any](c *Context, d *dispatcher, i int) (v T, ok bool) {
+	if d.args != nil && d.args[i] != nil {
+		var rv reflect.Value
+		if rv, ok = d.args[i].build(c, d.fn); ok {
+			v = rv.Interface().(T)
+		}
+		return
+	}
+
+	t := d.fn.Type().In(i)
+	x, ok := c.pick(d.in[i], t, &d.impl[i])
+	if !ok {
+		c.Unresolved(funcName(d.fn), t)
+		return
+	}
+	if x != nil {
+		v = x.(T)
+	}
+	return
+}
+
+// done 处理 handler 返回的 error, 与注入调用相同.
+func done(c *Context, err error) bool {
+	if err != nil {
+		c.HandleError(err)
+		return false
+	}
+	return true
+}
+
+// Func1 返回以注入方式调用 fn 的 Dispatcher. 与直接使用 fn 作为 handler 相比,
+// 参数的注入规则, 返回值和错误的处理都相同, 但派发时不使用反射调用, 性能更好.
+// 同类的适配器按参数个数 N 为 1 到 6, 支持的 fn 形式为:
+//
+//   FuncN    func(A1, ..., AN)
+//   FuncEN   func(A1, ..., AN) error
+//   FuncRN   func(A1, ..., AN) R
+//   FuncREN  func(A1, ..., AN) (R, error)
+//
+// 其中 R 以 render 规则输出. 例如:
+//
+//   r.Get("/user/:id", rivet.FuncRE2(func(p rivet.Params, db *sql.DB) (*User, error) {
+//       return findUser(db, p.Get("id"))
+//   }))
+func Func1[A1 any](fn func(A1)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, ok := arg[A1](c, &t.dispatcher, 0)
+		if ok {
+			fn(a1)
+		}
+		return ok
+	}
+	return t
+}
+
+// FuncE1 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncE1[A1 any](fn func(A1) error) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, ok := arg[A1](c, &t.dispatcher, 0)
+		return ok && done(c, fn(a1))
+	}
+	return t
+}
+
+// FuncR1 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncR1[A1, R any](fn func(A1) R) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, ok := arg[A1](c, &t.dispatcher, 0)
+		return ok && render(c, 0, fn(a1))
+	}
+	return t
+}
+
+// FuncRE1 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncRE1[A1, R any](fn func(A1) (R, error)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, ok := arg[A1](c, &t.dispatcher, 0)
+		if !ok {
+			return false
+		}
+		r, err := fn(a1)
+		return done(c, err) && render(c, 0, r)
+	}
+	return t
+}
+
+func args2[A1, A2 any](c *Context, d *dispatcher) (a1 A1, a2 A2, ok bool) {
+	if a1, ok = arg[A1](c, d, 0); !ok {
+		return
+	}
+	a2, ok = arg[A2](c, d, 1)
+	return
+}
+
+// Func2 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func Func2[A1, A2 any](fn func(A1, A2)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, ok := args2[A1, A2](c, &t.dispatcher)
+		if ok {
+			fn(a1, a2)
+		}
+		return ok
+	}
+	return t
+}
+
+// FuncE2 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncE2[A1, A2 any](fn func(A1, A2) error) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, ok := args2[A1, A2](c, &t.dispatcher)
+		return ok && done(c, fn(a1, a2))
+	}
+	return t
+}
+
+// FuncR2 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncR2[A1, A2, R any](fn func(A1, A2) R) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, ok := args2[A1, A2](c, &t.dispatcher)
+		return ok && render(c, 0, fn(a1, a2))
+	}
+	return t
+}
+
+// FuncRE2 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncRE2[A1, A2, R any](fn func(A1, A2) (R, error)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, ok := args2[A1, A2](c, &t.dispatcher)
+		if !ok {
+			return false
+		}
+		r, err := fn(a1, a2)
+		return done(c, err) && render(c, 0, r)
+	}
+	return t
+}
+
+func args3[A1, A2, A3 any](c *Context, d *dispatcher) (a1 A1, a2 A2, a3 A3, ok bool) {
+	if a1, ok = arg[A1](c, d, 0); !ok {
+		return
+	}
+	if a2, ok = arg[A2](c, d, 1); !ok {
+		return
+	}
+	a3, ok = arg[A3](c, d, 2)
+	return
+}
+
+// Func3 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func Func3[A1, A2, A3 any](fn func(A1, A2, A3)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, ok := args3[A1, A2, A3](c, &t.dispatcher)
+		if ok {
+			fn(a1, a2, a3)
+		}
+		return ok
+	}
+	return t
+}
+
+// FuncE3 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncE3[A1, A2, A3 any](fn func(A1, A2, A3) error) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, ok := args3[A1, A2, A3](c, &t.dispatcher)
+		return ok && done(c, fn(a1, a2, a3))
+	}
+	return t
+}
+
+// FuncR3 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncR3[A1, A2, A3, R any](fn func(A1, A2, A3) R) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, ok := args3[A1, A2, A3](c, &t.dispatcher)
+		return ok && render(c, 0, fn(a1, a2, a3))
+	}
+	return t
+}
+
+// FuncRE3 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncRE3[A1, A2, A3, R any](fn func(A1, A2, A3) (R, error)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, ok := args3[A1, A2, A3](c, &t.dispatcher)
+		if !ok {
+			return false
+		}
+		r, err := fn(a1, a2, a3)
+		return done(c, err) && render(c, 0, r)
+	}
+	return t
+}
+
+func args4[A1, A2, A3, A4 any](c *Context, d *dispatcher) (a1 A1, a2 A2, a3 A3, a4 A4, ok bool) {
+	if a1, ok = arg[A1](c, d, 0); !ok {
+		return
+	}
+	if a2, ok = arg[A2](c, d, 1); !ok {
+		return
+	}
+	if a3, ok = arg[A3](c, d, 2); !ok {
+		return
+	}
+	a4, ok = arg[A4](c, d, 3)
+	return
+}
+
+// Func4 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func Func4[A1, A2, A3, A4 any](fn func(A1, A2, A3, A4)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, ok := args4[A1, A2, A3, A4](c, &t.dispatcher)
+		if ok {
+			fn(a1, a2, a3, a4)
+		}
+		return ok
+	}
+	return t
+}
+
+// FuncE4 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncE4[A1, A2, A3, A4 any](fn func(A1, A2, A3, A4) error) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, ok := args4[A1, A2, A3, A4](c, &t.dispatcher)
+		return ok && done(c, fn(a1, a2, a3, a4))
+	}
+	return t
+}
+
+// FuncR4 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncR4[A1, A2, A3, A4, R any](fn func(A1, A2, A3, A4) R) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, ok := args4[A1, A2, A3, A4](c, &t.dispatcher)
+		return ok && render(c, 0, fn(a1, a2, a3, a4))
+	}
+	return t
+}
+
+// FuncRE4 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncRE4[A1, A2, A3, A4, R any](fn func(A1, A2, A3, A4) (R, error)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, ok := args4[A1, A2, A3, A4](c, &t.dispatcher)
+		if !ok {
+			return false
+		}
+		r, err := fn(a1, a2, a3, a4)
+		return done(c, err) && render(c, 0, r)
+	}
+	return t
+}
+
+func args5[A1, A2, A3, A4, A5 any](c *Context, d *dispatcher) (a1 A1, a2 A2, a3 A3, a4 A4, a5 A5, ok bool) {
+	if a1, ok = arg[A1](c, d, 0); !ok {
+		return
+	}
+	if a2, ok = arg[A2](c, d, 1); !ok {
+		return
+	}
+	if a3, ok = arg[A3](c, d, 2); !ok {
+		return
+	}
+	if a4, ok = arg[A4](c, d, 3); !ok {
+		return
+	}
+	a5, ok = arg[A5](c, d, 4)
+	return
+}
+
+// Func5 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func Func5[A1, A2, A3, A4, A5 any](fn func(A1, A2, A3, A4, A5)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, a5, ok := args5[A1, A2, A3, A4, A5](c, &t.dispatcher)
+		if ok {
+			fn(a1, a2, a3, a4, a5)
+		}
+		return ok
+	}
+	return t
+}
+
+// FuncE5 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncE5[A1, A2, A3, A4, A5 any](fn func(A1, A2, A3, A4, A5) error) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, a5, ok := args5[A1, A2, A3, A4, A5](c, &t.dispatcher)
+		return ok && done(c, fn(a1, a2, a3, a4, a5))
+	}
+	return t
+}
+
+// FuncR5 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncR5[A1, A2, A3, A4, A5, R any](fn func(A1, A2, A3, A4, A5) R) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, a5, ok := args5[A1, A2, A3, A4, A5](c, &t.dispatcher)
+		return ok && render(c, 0, fn(a1, a2, a3, a4, a5))
+	}
+	return t
+}
+
+// FuncRE5 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncRE5[A1, A2, A3, A4, A5, R any](fn func(A1, A2, A3, A4, A5) (R, error)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, a5, ok := args5[A1, A2, A3, A4, A5](c, &t.dispatcher)
+		if !ok {
+			return false
+		}
+		r, err := fn(a1, a2, a3, a4, a5)
+		return done(c, err) && render(c, 0, r)
+	}
+	return t
+}
+
+func args6[A1, A2, A3, A4, A5, A6 any](c *Context, d *dispatcher) (a1 A1, a2 A2, a3 A3, a4 A4, a5 A5, a6 A6, ok bool) {
+	if a1, ok = arg[A1](c, d, 0); !ok {
+		return
+	}
+	if a2, ok = arg[A2](c, d, 1); !ok {
+		return
+	}
+	if a3, ok = arg[A3](c, d, 2); !ok {
+		return
+	}
+	if a4, ok = arg[A4](c, d, 3); !ok {
+		return
+	}
+	if a5, ok = arg[A5](c, d, 4); !ok {
+		return
+	}
+	a6, ok = arg[A6](c, d, 5)
+	return
+}
+
+// Func6 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func Func6[A1, A2, A3, A4, A5, A6 any](fn func(A1, A2, A3, A4, A5, A6)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, a5, a6, ok := args6[A1, A2, A3, A4, A5, A6](c, &t.dispatcher)
+		if ok {
+			fn(a1, a2, a3, a4, a5, a6)
+		}
+		return ok
+	}
+	return t
+}
+
+// FuncE6 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncE6[A1, A2, A3, A4, A5, A6 any](fn func(A1, A2, A3, A4, A5, A6) error) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, a5, a6, ok := args6[A1, A2, A3, A4, A5, A6](c, &t.dispatcher)
+		return ok && done(c, fn(a1, a2, a3, a4, a5, a6))
+	}
+	return t
+}
+
+// FuncR6 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncR6[A1, A2, A3, A4, A5, A6, R any](fn func(A1, A2, A3, A4, A5, A6) R) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, a5, a6, ok := args6[A1, A2, A3, A4, A5, A6](c, &t.dispatcher)
+		return ok && render(c, 0, fn(a1, a2, a3, a4, a5, a6))
+	}
+	return t
+}
+
+// FuncRE6 返回以注入方式调用 fn 的 Dispatcher, 参见 Func1.
+func FuncRE6[A1, A2, A3, A4, A5, A6, R any](fn func(A1, A2, A3, A4, A5, A6) (R, error)) Dispatcher {
+	t := newTyped(fn)
+	t.call = func(c *Context) bool {
+		a1, a2, a3, a4, a5, a6, ok := args6[A1, A2, A3, A4, A5, A6](c, &t.dispatcher)
+		if !ok {
+			return false
+		}
+		r, err := fn(a1, a2, a3, a4, a5, a6)
+		return done(c, err) && render(c, 0, r)
+	}
+	return t
+}
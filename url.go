@@ -0,0 +1,106 @@
+package rivet
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URL 调用 Build 方法, 以 params 中的原始字符串生成 t 的 URL.Path.
+func (t *Trie) URL(params Params) (string, error) {
+	return t.Build(params.Gets())
+}
+
+// Build 使用 values 填充 t 的完整 pattern, 返回转义后的 URL.Path. 反向路由.
+// values 的 key 与 Params 中的 Name 对应, 填充规则:
+//
+//   ":name"  取 values["name"], 必须存在且能通过节点 Matcher 的匹配.
+//            Matcher 被调用时 req 参数为 nil.
+//   "*"      取 values["*"], 可以省略.
+//   "**"     取 values["**"], 可以包含分隔符. 后缀匹配 "**suffix" 会在缺少后缀时补全.
+//   "?"      取 values["?"], 缺省省略可选字符.
+//
+// 除 "**" 外, 参数值都不能包含分隔符.
+func (t *Trie) Build(values map[string]string) (string, error) {
+	var nodes []*Trie
+	for n := t; n != nil; n = n.parent {
+		nodes = append(nodes, n)
+	}
+
+	var buf []string
+	for i := len(nodes) - 1; i >= 0; i-- {
+		s, err := nodes[i].build(values)
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, s)
+	}
+	return strings.Join(buf, ""), nil
+}
+
+// build 返回节点 t 对应的 URL.Path 片段.
+func (t *Trie) build(values map[string]string) (string, error) {
+	switch t.kind {
+	case 0, 0xff:
+		return t.escape(t.pattern), nil
+
+	case 0xfc: // "*"
+		s := values["*"]
+		if strings.IndexByte(s, t.sep) != -1 {
+			return "", t.buildError(s)
+		}
+		return t.escape(s), nil
+
+	case 0xfd: // "**", "**suffix"
+		s, ok := values["**"]
+		if !ok {
+			return "", fmt.Errorf("rivet: missing parameter %q for %q", "**", t.String())
+		}
+		if suffix := t.pattern[2:]; !strings.HasSuffix(s, suffix) {
+			s += suffix
+		}
+		return t.escape(s), nil
+
+	case 0xfe: // "x?", "?"
+		s := values["?"]
+		if s == "" {
+			return "", nil
+		}
+		if len(s) != 1 || t.pattern[0] != '?' && s[0] != t.pattern[0] {
+			return "", t.buildError(s)
+		}
+		return t.escape(s), nil
+	}
+
+	// ":name"
+	name := t.pattern[1:int(t.kind)]
+	s, ok := values[name]
+	if !ok {
+		return "", fmt.Errorf("rivet: missing parameter %q for %q", name, t.String())
+	}
+
+	if s == "" || strings.IndexByte(s, t.sep) != -1 {
+		return "", t.buildError(s)
+	}
+
+	if t.matcher != nil {
+		val := t.matcher.Match(s, nil)
+		if _, isErr := val.(error); val == nil || isErr {
+			return "", t.buildError(s)
+		}
+	}
+	return t.escape(s), nil
+}
+
+func (t *Trie) buildError(s string) error {
+	return fmt.Errorf("rivet: invalid value %q for %q in %q", s, t.pattern, t.String())
+}
+
+// escape 保留分隔符, 对 s 的每一段进行 URL.Path 转义.
+func (t *Trie) escape(s string) string {
+	a := strings.Split(s, string(t.sep))
+	for i, v := range a {
+		a[i] = url.PathEscape(v)
+	}
+	return strings.Join(a, string(t.sep))
+}
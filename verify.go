@@ -0,0 +1,170 @@
+package rivet
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"unsafe"
+)
+
+var idResponse = TypePointerOf([]*Response{})
+
+// InjectError 表示注入函数的参数无法被满足.
+// 注入调用时 Pick 失败, 以 *InjectError 调用 Context.HandleError; Verify 也返回它.
+type InjectError struct {
+	Func  string         // 函数名, 参见 Describe
+	Types []reflect.Type // 无法注入的参数类型
+}
+
+func (e *InjectError) Error() string {
+	a := make([]string, len(e.Types))
+	for i, t := range e.Types {
+		a[i] = t.String()
+	}
+	return fmt.Sprintf("rivet: unresolved parameter %s of %s", strings.Join(a, ", "), e.Func)
+}
+
+func (e *InjectError) Status() int         { return http.StatusInternalServerError }
+func (e *InjectError) Header() http.Header { return nil }
+func (e *InjectError) Message() string     { return http.StatusText(http.StatusInternalServerError) }
+
+// Unresolved 在名为 fn 的注入函数所需的类型 t 被 Pick 失败时调用.
+// 如果之前没有错误被处理过, 比如 provider 返回的错误, 以 *InjectError 调用 c.HandleError.
+// 该方法供生成的 Dispatcher 等自定义注入调用使用.
+func (c *Context) Unresolved(fn string, t reflect.Type) {
+	if _, handled := c.partner[idError]; !handled {
+		c.HandleError(&InjectError{
+			Func:  fn,
+			Types: []reflect.Type{t},
+		})
+	}
+}
+
+// Verify 在注册时检查 handler 的注入参数能否被满足, 返回 nil 或者由 *InjectError 组成的错误.
+// 可被满足的类型有:
+//
+//   Context.Pick 直接支持的 *Context, Params, http.ResponseWriter, *http.Request,
+//   context.Context.
+//   i 及其父级中关联的变量和 provider, i 可以为 nil.
+//   之前的 handler 中以非函数值关联的变量和以 Provide 注册的 provider.
+//   Defer 包装的 handler 中的 error 和 *Response.
+//
+// Scope 会替换之后使用的 Injector. 在 handler 中以 Context.Map 等方法动态关联的类型无法被检查,
+// 所以 Verify 是可选的. 参见 Rivet.Verify.
+func Verify(i *Injector, handler ...interface{}) error {
+	return verify(i, false, handler)
+}
+
+// verify 实现 Verify, assignable 为 true 时按 Rivet.Assignable 的规则检查接口类型参数.
+func verify(i *Injector, assignable bool, handler []interface{}) error {
+	v := verifier{
+		injector:   i,
+		assignable: assignable,
+		known:      map[unsafe.Pointer]reflect.Type{},
+	}
+	if d := ToDispatcher(handler...); d != nil {
+		v.verify(d)
+	}
+	return errors.Join(v.errs...)
+}
+
+type verifier struct {
+	injector   *Injector
+	assignable bool
+	known      map[unsafe.Pointer]reflect.Type // 之前的 handler 关联的类型
+	errs       []error
+}
+
+func (v *verifier) verify(d Dispatcher) {
+	switch d := d.(type) {
+	case dispatchs:
+		for _, d := range d.queue {
+			v.verify(d)
+		}
+		if len(d.after) != 0 {
+			v.known[idError] = reflect.TypeOf([]error{}).Elem()
+			v.known[idResponse] = reflect.TypeOf(&Response{})
+			for _, d := range d.after {
+				v.verify(d)
+			}
+		}
+	case dispatcher:
+		v.check(d.fn, d.args)
+	case *typed:
+		v.check(d.fn, d.args)
+	case FuncDispatcher:
+		// 生成的代码以类型指针精确查找参数, 不展开 In 字段, 也不按 Assignable 查找
+		assignable := v.assignable
+		v.assignable = false
+		v.check(reflect.ValueOf(d.Func()), nil)
+		v.assignable = assignable
+	case dispatchProvide:
+		v.check(d.p.fn, nil)
+		v.known[d.p.out] = d.p.fn.Type().Out(0)
+	case dispatch:
+		v.known[TypePointerOf(d.i)] = reflect.TypeOf(d.i)
+	case dispatchScope:
+		v.injector = d.i
+	}
+}
+
+// check 记录 fn 中无法被满足的参数, args 非 nil 时包含 In 结构体参数的字段.
+func (v *verifier) check(fn reflect.Value, args []*object) {
+	var types []reflect.Type
+	t := fn.Type()
+	for i := 0; i < t.NumIn(); i++ {
+		if args != nil && args[i] != nil {
+			for _, f := range args[i].fields {
+				if ft := args[i].typ.Field(f.index).Type; !f.optional && !v.has(ft) {
+					types = append(types, ft)
+				}
+			}
+		} else if !v.has(t.In(i)) {
+			types = append(types, t.In(i))
+		}
+	}
+	if types != nil {
+		v.errs = append(v.errs, &InjectError{Func: funcName(fn), Types: types})
+	}
+}
+
+// has 返回类型 t 是否可被满足.
+func (v *verifier) has(t reflect.Type) bool {
+	id := TypePointerOf(t)
+	switch id {
+	case idContext, idRequest, idResponseWriter, idParams, idStdContext:
+		return true
+	}
+	if _, ok := v.known[id]; ok {
+		return true
+	}
+	for i := v.injector; i != nil; i = i.parent {
+		if _, ok := i.values[id]; ok || i.providers[id] != nil {
+			return true
+		}
+	}
+
+	if !v.assignable || t.Kind() != reflect.Interface {
+		return false
+	}
+	for _, k := range v.known {
+		if k.Implements(t) {
+			return true
+		}
+	}
+	for i := v.injector; i != nil; i = i.parent {
+		for _, x := range i.values {
+			if x != nil && reflect.TypeOf(x).Implements(t) {
+				return true
+			}
+		}
+		for _, p := range i.providers {
+			if p.fn.Type().Out(0).Implements(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,244 @@
+package rivet
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// RouteInfo 描述一个路由终端节点, 用于遍历路由表.
+type RouteInfo struct {
+	Name     string   // 路由名, 只有 Rivet.Walk 会设置
+	Method   string   // HTTP method
+	Pattern  string   // 完整 pattern
+	Params   []string // 参数名, 与匹配得到的 Params 一一对应, Catch-All 为 "**"
+	Matchers []string // 与 Params 对应的 Matcher 名, 缺省的为 "string", 正则为 "reg", Catch-All 为 ""
+	Handler  string   // 处理器描述, 通常是函数名, 多个处理器以 ", " 分隔
+	Trie     *Trie    // 终端节点
+}
+
+func (ri RouteInfo) String() string {
+	return ri.Method + " " + ri.Pattern + " " + ri.Handler
+}
+
+// Walk 以深度优先的顺序遍历 t 的终端节点, 即 Word 非 nil 的节点,
+// 顺序与匹配顺序一致. 如果 fn 返回错误, 遍历终止并返回该错误.
+func (t *Trie) Walk(fn func(*Trie) error) error {
+	if t.Word != nil {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range t.childs {
+		if err := c.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Info 返回 t 的 RouteInfo, method 由调用者提供.
+func (t *Trie) Info(method string) RouteInfo {
+	ri := RouteInfo{
+		Method:  method,
+		Pattern: t.String(),
+		Handler: Describe(t.Word),
+		Trie:    t,
+	}
+
+	if t.nop == 0 {
+		return ri
+	}
+
+	ri.Params = make([]string, t.nop)
+	ri.Matchers = make([]string, t.nop)
+
+	for n := t; n != nil; n = n.parent {
+		if n.kind != 0xfd && n.kind >= 0xfc || n.kind == 0 {
+			continue
+		}
+
+		i := int(n.nop) - 1
+		if n.kind == 0xfd {
+			ri.Params[i] = "**"
+			continue
+		}
+
+		ri.Params[i] = n.pattern[1:int(n.kind)]
+		ri.Matchers[i] = matcherName(n)
+	}
+	return ri
+}
+
+// matcherName 返回 ":name" 节点的 Matcher 名.
+func matcherName(t *Trie) string {
+	if t.matcher == nil {
+		return "string"
+	}
+
+	exp := strings.TrimSpace(t.pattern[int(t.kind):])
+	name := strings.SplitN(exp, " ", 2)[0]
+	if _, ok := Matches[name]; ok {
+		return name
+	}
+	return "reg"
+}
+
+// Describe 返回 handler 的描述, 通常是函数名. handler 可以是 ToDispatcher 的返回值.
+func Describe(handler interface{}) string {
+	switch d := handler.(type) {
+	case nil:
+		return ""
+	case dispatchs:
+		return describe(d.queue)
+	case []Dispatcher:
+		return describe(d)
+	case []interface{}:
+		a := make([]string, len(d))
+		for i, h := range d {
+			a[i] = Describe(h)
+		}
+		return strings.Join(a, ", ")
+	case dispatcher:
+		return funcName(d.fn)
+	case *typed:
+		return funcName(d.fn)
+	case FuncDispatcher:
+		return funcName(reflect.ValueOf(d.Func()))
+	case dispatchContext:
+		if d.c != nil {
+			return Describe(d.c)
+		}
+		return Describe(d.r)
+	case dispatchHandle:
+		if d.c != nil {
+			return Describe(d.c)
+		}
+		return Describe(d.r)
+	case dispatchParams:
+		if d.c != nil {
+			return Describe(d.c)
+		}
+		return Describe(d.r)
+	case dispatchHandler:
+		return Describe(d.Handler)
+	case dispatchEmpty:
+		return Describe((func())(d))
+	case dispatch:
+		return fmt.Sprintf("%T", d.i)
+	case http.HandlerFunc:
+		return funcName(reflect.ValueOf(d))
+	}
+
+	if v := reflect.ValueOf(handler); v.Kind() == reflect.Func {
+		return funcName(v)
+	}
+	return fmt.Sprintf("%T", handler)
+}
+
+func describe(ds []Dispatcher) string {
+	a := make([]string, len(ds))
+	for i, d := range ds {
+		a[i] = Describe(d)
+	}
+	return strings.Join(a, ", ")
+}
+
+func funcName(fn reflect.Value) string {
+	if fn.IsNil() {
+		return ""
+	}
+	if f := runtime.FuncForPC(fn.Pointer()); f != nil {
+		return f.Name()
+	}
+	return fn.Type().String()
+}
+
+// Walk 遍历 r 中所有的路由终端节点, method 按字母顺序排列.
+// 如果 fn 返回错误, 遍历终止并返回该错误.
+func (r Router) Walk(fn func(RouteInfo) error) error {
+	methods := make([]string, 0, len(r))
+	for method := range r {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	for _, method := range methods {
+		err := r[method].Walk(func(t *Trie) error {
+			return fn(t.Info(method))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RouteInfos 返回 r 中所有路由终端节点的 RouteInfo, 顺序同 Walk.
+func (r Router) RouteInfos() []RouteInfo {
+	return routeInfos(r.Walk)
+}
+
+// Print 输出路由表到 os.Stdout.
+func (r Router) Print() {
+	r.Fprint(os.Stdout)
+}
+
+// Fprint 输出路由表到 w, 每行一个路由: method pattern handler.
+func (r Router) Fprint(w io.Writer) {
+	fprint(w, r.Walk)
+}
+
+func routeInfos(walk func(func(RouteInfo) error) error) []RouteInfo {
+	var a []RouteInfo
+	walk(func(ri RouteInfo) error {
+		a = append(a, ri)
+		return nil
+	})
+	return a
+}
+
+func fprint(w io.Writer, walk func(func(RouteInfo) error) error) {
+	if w == nil {
+		return
+	}
+	walk(func(ri RouteInfo) error {
+		_, err := fmt.Fprintf(w, "%-7s %s  %s\n", ri.Method, ri.Pattern, ri.Handler)
+		return err
+	})
+}
+
+// Walk 遍历 r 中所有的路由终端节点, 参见 Router.Walk. 命名路由会设置 RouteInfo.Name.
+func (r *Rivet) Walk(fn func(RouteInfo) error) error {
+	tab := r.table()
+	names := make(map[*Trie]string, len(tab.routes))
+	for name, route := range tab.routes {
+		names[route.Trie] = name
+	}
+
+	return tab.router.Walk(func(ri RouteInfo) error {
+		ri.Name = names[ri.Trie]
+		return fn(ri)
+	})
+}
+
+// RouteInfos 返回 r 中所有路由终端节点的 RouteInfo, 顺序同 Walk.
+func (r *Rivet) RouteInfos() []RouteInfo {
+	return routeInfos(r.Walk)
+}
+
+// Print 输出路由表到 os.Stdout.
+func (r *Rivet) Print() {
+	r.Fprint(os.Stdout)
+}
+
+// Fprint 输出路由表到 w, 格式同 Router.Fprint.
+func (r *Rivet) Fprint(w io.Writer) {
+	fprint(w, r.Walk)
+}